@@ -0,0 +1,353 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package admin provides an http.Handler for monitoring and managing a running
+// pipeline.Pipeline.
+//
+// The handler serves the following routes relative to where it is mounted:
+//
+//	GET  /         a minimal HTML dashboard
+//	GET  /status   the pipeline status as JSON
+//	GET  /health   the streaming health as JSON; 503 if a stage has stalled
+//	GET  /dot      the pipeline topology in the Graphviz DOT language
+//	GET  /mermaid  the pipeline topology as a Mermaid flowchart
+//	POST /abort    aborts the generator
+//	POST /pause    pauses the generator, or a stage with ?stage=name
+//	POST /resume   resumes the generator, or a stage with ?stage=name
+//	POST /resize   sets the concurrency of a stage; ?stage=name&concurrency=n
+//
+// Mount it under a prefix with http.StripPrefix:
+//
+//	http.Handle("/pipeline/", http.StripPrefix("/pipeline", admin.NewHandler(p)))
+//
+// The handler has no authentication; anyone who can reach it can abort or
+// resize the pipeline, so mount it on an internal listener or behind your own
+// authentication. POST requests a browser marks as coming from another site,
+// by the Sec-Fetch-Site or Origin header, are rejected with 403 so that a web
+// page cannot drive the pipeline through a user's browser.
+package admin
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+// Status is the JSON document served by /status
+type Status struct {
+	Running   bool          `json:"running"`
+	Paused    bool          `json:"paused"`
+	Generator string        `json:"generator"`
+	Stages    []StageStatus `json:"stages"`
+}
+
+// StageStatus is the status of a single stage
+type StageStatus struct {
+	Name             string  `json:"name"`
+	Paused           bool    `json:"paused"`
+	Workers          int     `json:"workers"`
+	Active           int     `json:"active"`
+	Queued           int     `json:"queued"`
+	Capacity         int     `json:"capacity"`
+	Processed        uint64  `json:"processed"`
+	InFlight         int     `json:"in_flight"`
+	OldestInFlightMs float64 `json:"oldest_in_flight_ms"`
+	Errors           uint64  `json:"errors"`
+	Skipped          uint64  `json:"skipped"`
+	Dropped          uint64  `json:"dropped"`
+	Spilled          uint64  `json:"spilled"`
+	Rejected         uint64  `json:"rejected"`
+}
+
+// Health is the JSON document served by /health
+type Health struct {
+	Live      bool          `json:"live"`
+	Error     string        `json:"error,omitempty"`
+	Idle      bool          `json:"idle"`
+	Generated uint64        `json:"generated"`
+	Rate      float64       `json:"rate"`
+	Stages    []StageHealth `json:"stages"`
+}
+
+// StageHealth is the health of a single stage
+type StageHealth struct {
+	Name      string  `json:"name"`
+	Processed uint64  `json:"processed"`
+	Rate      float64 `json:"rate"`
+	Stalled   bool    `json:"stalled"`
+}
+
+// Handler serves the admin endpoints for a Pipeline
+type Handler struct {
+	_   struct{}
+	p   *pipeline.Pipeline
+	mux *http.ServeMux
+}
+
+// NewHandler creates a Handler for the pipeline
+func NewHandler(p *pipeline.Pipeline) *Handler {
+	h := &Handler{
+		p:   p,
+		mux: http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/", h.dashboard)
+	h.mux.HandleFunc("/status", h.status)
+	h.mux.HandleFunc("/health", h.health)
+	h.mux.HandleFunc("/dot", h.graph(p.DOT))
+	h.mux.HandleFunc("/mermaid", h.graph(p.Mermaid))
+	h.mux.HandleFunc("/abort", post(h.abort))
+	h.mux.HandleFunc("/pause", post(h.pause))
+	h.mux.HandleFunc("/resume", post(h.resume))
+	h.mux.HandleFunc("/resize", post(h.resize))
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Status returns the current status of the pipeline
+func (h *Handler) Status() Status {
+	snap := h.p.Snapshot()
+	status := Status{
+		Running:   snap.Running,
+		Paused:    snap.Paused,
+		Generator: snap.Generator,
+		Stages:    make([]StageStatus, len(snap.Stages)),
+	}
+	for i, s := range snap.Stages {
+		status.Stages[i] = StageStatus{
+			Name:             s.Name,
+			Paused:           s.Paused,
+			Workers:          s.Workers,
+			Active:           s.Active,
+			Queued:           s.Queued,
+			Capacity:         s.Capacity,
+			Processed:        s.Processed,
+			InFlight:         s.InFlight,
+			OldestInFlightMs: float64(s.OldestInFlight) / float64(time.Millisecond),
+			Errors:           s.Errors,
+			Skipped:          s.Skipped,
+			Dropped:          s.Dropped,
+			Spilled:          s.Spilled,
+			Rejected:         s.Rejected,
+		}
+	}
+	return status
+}
+
+func (h *Handler) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Status())
+}
+
+func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ph := h.p.Health()
+	health := Health{
+		Live:      true,
+		Idle:      ph.Idle,
+		Generated: ph.Generated,
+		Rate:      ph.Rate,
+		Stages:    make([]StageHealth, len(ph.Stages)),
+	}
+	for i, s := range ph.Stages {
+		health.Stages[i] = StageHealth{
+			Name:      s.Name,
+			Processed: s.Processed,
+			Rate:      s.Rate,
+			Stalled:   s.Stalled,
+		}
+	}
+
+	code := http.StatusOK
+	if err := h.p.Live(); err != nil {
+		health.Live, health.Error = false, err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, health)
+}
+
+func (h *Handler) graph(render func() string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(render()))
+	}
+}
+
+func (h *Handler) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboard.Execute(w, h.Status()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) abort(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, h.p.Abort())
+}
+
+func (h *Handler) pause(w http.ResponseWriter, r *http.Request) {
+	if stage := r.FormValue("stage"); stage != "" {
+		respond(w, r, h.p.PauseStage(stage))
+		return
+	}
+	h.p.Pause()
+	respond(w, r, nil)
+}
+
+func (h *Handler) resume(w http.ResponseWriter, r *http.Request) {
+	if stage := r.FormValue("stage"); stage != "" {
+		respond(w, r, h.p.ResumeStage(stage))
+		return
+	}
+	h.p.Resume()
+	respond(w, r, nil)
+}
+
+func (h *Handler) resize(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.FormValue("concurrency"))
+	if err != nil {
+		http.Error(w, "concurrency must be an integer", http.StatusBadRequest)
+		return
+	}
+	respond(w, r, h.p.Resize(r.FormValue("stage"), n))
+}
+
+// respond reports the result of an action; browsers posting the dashboard
+// forms are sent back to the dashboard
+func respond(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		code := http.StatusConflict
+		switch err {
+		case pipeline.ErrNoStage:
+			code = http.StatusNotFound
+		case pipeline.ErrConcurrency:
+			code = http.StatusBadRequest
+		}
+		writeJSON(w, code, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if r.FormValue("redirect") != "" {
+		http.Redirect(w, r, "./", http.StatusSeeOther)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+}
+
+func post(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "cross-site request", http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// sameOrigin reports whether a request came from the handler's own pages or
+// from outside a browser; clients that send neither header are allowed
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+var dashboard = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="2">
+<title>pipeline: {{.Generator}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+form { display: inline; }
+</style>
+</head>
+<body>
+<h1>{{.Generator}}</h1>
+<p>running: {{.Running}}, paused: {{.Paused}}
+<form method="post" action="abort"><input type="hidden" name="redirect" value="1"><button>abort</button></form>
+<form method="post" action="pause"><input type="hidden" name="redirect" value="1"><button>pause</button></form>
+<form method="post" action="resume"><input type="hidden" name="redirect" value="1"><button>resume</button></form>
+</p>
+<table>
+<tr><th>stage</th><th>workers</th><th>active</th><th>queued</th><th>capacity</th><th>processed</th><th>in flight</th><th>oldest (ms)</th><th>errors</th><th>skipped</th><th>dropped</th><th>spilled</th><th>rejected</th><th>resize</th><th></th></tr>
+{{range .Stages}}<tr>
+<td>{{.Name}}</td><td>{{.Workers}}</td><td>{{.Active}}</td><td>{{.Queued}}</td><td>{{.Capacity}}</td><td>{{.Processed}}</td><td>{{.InFlight}}</td><td>{{printf "%.1f" .OldestInFlightMs}}</td><td>{{.Errors}}</td><td>{{.Skipped}}</td><td>{{.Dropped}}</td><td>{{.Spilled}}</td><td>{{.Rejected}}</td>
+<td><form method="post" action="resize"><input type="hidden" name="redirect" value="1"><input type="hidden" name="stage" value="{{.Name}}"><input type="number" name="concurrency" min="1" value="{{.Workers}}" size="3"><button>set</button></form></td>
+<td><form method="post" action="{{if .Paused}}resume{{else}}pause{{end}}"><input type="hidden" name="redirect" value="1"><input type="hidden" name="stage" value="{{.Name}}"><button>{{if .Paused}}resume{{else}}pause{{end}}</button></form></td>
+</tr>
+{{end}}</table>
+<p><a href="status">json</a> | <a href="dot">dot</a> | <a href="mermaid">mermaid</a></p>
+</body>
+</html>
+`))
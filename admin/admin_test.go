@@ -0,0 +1,256 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package admin_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/admin"
+)
+
+type generator struct {
+	quit chan struct{}
+}
+
+func (g *generator) Name() string {
+	return "generator"
+}
+
+func (g *generator) Next() interface{} {
+	select {
+	case <-g.quit:
+		return nil
+	default:
+		return 1
+	}
+}
+
+func (g *generator) Abort() {
+	close(g.quit)
+}
+
+type stage struct{}
+
+func (s *stage) Name() string {
+	return "stage"
+}
+
+func (s *stage) Concurrency() int {
+	return 2
+}
+
+func (s *stage) Process(interface{}) {
+}
+
+func running() (*pipeline.Pipeline, chan error) {
+	p := pipeline.New()
+	p.SetGenerator(&generator{quit: make(chan struct{})})
+	p.AddStage(&stage{})
+
+	done := make(chan error)
+	go func() {
+		done <- p.Run()
+	}()
+	for !p.Snapshot().Running {
+	}
+	return p, done
+}
+
+func do(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestStatus(t *testing.T) {
+	p, done := running()
+	h := admin.NewHandler(p)
+
+	w := do(h, "GET", "/status")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v", w.Code)
+	}
+
+	var status admin.Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Running || status.Generator != "generator" || len(status.Stages) != 1 || status.Stages[0].Workers != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	w = do(h, "GET", "/")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<td>stage</td>") {
+		t.Errorf("expected the dashboard to list the stage")
+	}
+
+	if w := do(h, "POST", "/abort"); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %v", w.Code)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("error should be nil")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	p, done := running()
+	defer func() {
+		p.Abort()
+		<-done
+	}()
+	h := admin.NewHandler(p)
+
+	w := do(h, "GET", "/health")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v", w.Code)
+	}
+
+	var health admin.Health
+	if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if !health.Live || len(health.Stages) != 1 || health.Stages[0].Name != "stage" {
+		t.Errorf("unexpected health %+v", health)
+	}
+}
+
+func TestResize(t *testing.T) {
+	p, done := running()
+	defer func() {
+		p.Abort()
+		<-done
+	}()
+	h := admin.NewHandler(p)
+
+	tests := []struct {
+		method string
+		target string
+		code   int
+	}{
+		{"GET", "/resize?stage=stage&concurrency=4", http.StatusMethodNotAllowed},
+		{"POST", "/resize?stage=stage&concurrency=x", http.StatusBadRequest},
+		{"POST", "/resize?stage=stage&concurrency=0", http.StatusBadRequest},
+		{"POST", "/resize?stage=nope&concurrency=4", http.StatusNotFound},
+		{"POST", "/resize?stage=stage&concurrency=4", http.StatusOK},
+	}
+	for _, test := range tests {
+		if w := do(h, test.method, test.target); w.Code != test.code {
+			t.Errorf("%v %v: expected %v, got %v", test.method, test.target, test.code, w.Code)
+		}
+	}
+
+	if n := p.Snapshot().Stages[0].Workers; n != 4 {
+		t.Errorf("expected 4 workers, got %v", n)
+	}
+}
+
+func TestCrossSite(t *testing.T) {
+	p, done := running()
+	defer func() {
+		p.Abort()
+		<-done
+	}()
+	h := admin.NewHandler(p)
+
+	tests := []struct {
+		header string
+		value  string
+		code   int
+	}{
+		{"", "", http.StatusOK},
+		{"Sec-Fetch-Site", "same-origin", http.StatusOK},
+		{"Sec-Fetch-Site", "none", http.StatusOK},
+		{"Sec-Fetch-Site", "same-site", http.StatusForbidden},
+		{"Sec-Fetch-Site", "cross-site", http.StatusForbidden},
+		{"Origin", "http://example.com", http.StatusOK},
+		{"Origin", "http://evil.example", http.StatusForbidden},
+		{"Origin", "null", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/pause", nil)
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%v: %v: expected %v, got %v", test.header, test.value, test.code, w.Code)
+		}
+		if w.Code == http.StatusForbidden && p.Snapshot().Paused {
+			t.Errorf("%v: %v: pipeline should not be paused", test.header, test.value)
+		}
+		p.Resume()
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	p, done := running()
+	defer func() {
+		p.Abort()
+		<-done
+	}()
+	h := admin.NewHandler(p)
+
+	tests := []struct {
+		target string
+		code   int
+		paused bool
+		stage  bool
+	}{
+		{"/pause", http.StatusOK, true, false},
+		{"/resume", http.StatusOK, false, false},
+		{"/pause?stage=stage", http.StatusOK, false, true},
+		{"/pause?stage=nope", http.StatusNotFound, false, true},
+		{"/resume?stage=stage", http.StatusOK, false, false},
+	}
+	for _, test := range tests {
+		if w := do(h, "POST", test.target); w.Code != test.code {
+			t.Errorf("%v: expected %v, got %v", test.target, test.code, w.Code)
+		}
+		status := h.Status()
+		if status.Paused != test.paused || status.Stages[0].Paused != test.stage {
+			t.Errorf("%v: unexpected status %+v", test.target, status)
+		}
+	}
+}
+
+func TestGraph(t *testing.T) {
+	p, done := running()
+	defer func() {
+		p.Abort()
+		<-done
+	}()
+	h := admin.NewHandler(p)
+
+	if w := do(h, "GET", "/dot"); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "digraph pipeline {") {
+		t.Errorf("expected a DOT graph, got %v", w.Body.String())
+	}
+	if w := do(h, "GET", "/mermaid"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "processed=") {
+		t.Errorf("expected a Mermaid graph with live counts, got %v", w.Body.String())
+	}
+}
@@ -0,0 +1,189 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package cache memoizes an expensive stage. A cached stage computes a key
+// from each job and looks up the result stored for it; on a hit the result is
+// applied to the job and the wrapped stage is not called. On a miss the
+// wrapped stage processes the job and its result is stored. Results older
+// than the time to live are processed again.
+//
+//	c := cache.Wrap(hash.Stage, cache.NewMemory(), cache.Options{
+//		Key:    func(job interface{}) (string, bool) { return job.(*Job).Path, true },
+//		Result: func(job interface{}) interface{} { return job.(*Job).Hash },
+//		Apply:  func(job, result interface{}) { job.(*Job).Hash = result.(string) },
+//		TTL:    24 * time.Hour,
+//	})
+//	p.AddStage(c)
+package cache
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+// Store holds results by key and must be safe for concurrent use
+type Store interface {
+	Get(key string) (result interface{}, stored time.Time, ok bool)
+	Put(key string, result interface{}, stored time.Time) error
+	Delete(key string) error
+}
+
+// Options configures a cached stage. Key returns the key of a job, or false
+// to process the job without the cache. Result extracts the result of the
+// stage from a processed job and Apply applies a stored result to a job; all
+// three are required. TTL, when greater than 0, bounds the age of a result.
+type Options struct {
+	_      struct{}
+	Key    func(job interface{}) (string, bool)
+	Result func(job interface{}) interface{}
+	Apply  func(job, result interface{})
+	TTL    time.Duration
+}
+
+// Stage wraps a stage with a cache of its results. It keeps the name and
+// concurrency of the wrapped stage; a Retryable stage is still retried and a
+// job that fails is not cached. An Emitter cannot be cached.
+type Stage struct {
+	_       struct{}
+	stage   pipeline.Stage
+	store   Store
+	opts    Options
+	mu      sync.Mutex
+	clock   pipeline.Clock
+	hits    uint64
+	misses  uint64
+	expired uint64
+	errors  uint64
+}
+
+// Wrap returns a stage that caches the results of s in store
+func Wrap(s pipeline.Stage, store Store, opts Options) *Stage {
+	if opts.Key == nil || opts.Result == nil || opts.Apply == nil {
+		panic("cache: Options.Key, Result and Apply are required")
+	}
+	return &Stage{
+		stage: s,
+		store: store,
+		opts:  opts,
+		clock: pipeline.SystemClock{},
+	}
+}
+
+// Name implements pipeline.Stage
+func (s *Stage) Name() string {
+	return s.stage.Name()
+}
+
+// Concurrency implements pipeline.Stage
+func (s *Stage) Concurrency() int {
+	return s.stage.Concurrency()
+}
+
+// Process implements pipeline.Stage
+func (s *Stage) Process(job interface{}) {
+	s.TryProcess(job)
+}
+
+// TryProcess implements pipeline.Retryable
+func (s *Stage) TryProcess(job interface{}) error {
+	key, ok := s.opts.Key(job)
+	if !ok {
+		return s.process(job)
+	}
+
+	now := s.now()
+	if result, stored, ok := s.store.Get(key); ok {
+		if s.opts.TTL <= 0 || now.Sub(stored) < s.opts.TTL {
+			atomic.AddUint64(&s.hits, 1)
+			s.opts.Apply(job, result)
+			return nil
+		}
+		atomic.AddUint64(&s.expired, 1)
+		s.store.Delete(key)
+	}
+
+	atomic.AddUint64(&s.misses, 1)
+	if err := s.process(job); err != nil {
+		return err
+	}
+	if err := s.store.Put(key, s.opts.Result(job), s.now()); err != nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	return nil
+}
+
+func (s *Stage) process(job interface{}) error {
+	if r, ok := s.stage.(pipeline.Retryable); ok {
+		return r.TryProcess(job)
+	}
+	s.stage.Process(job)
+	return nil
+}
+
+// SetClock implements pipeline.Clocked; the clock is passed on to the wrapped
+// stage
+func (s *Stage) SetClock(c pipeline.Clock) {
+	s.mu.Lock()
+	s.clock = c
+	s.mu.Unlock()
+	if clocked, ok := s.stage.(pipeline.Clocked); ok {
+		clocked.SetClock(c)
+	}
+}
+
+// SetRand implements pipeline.Seeded for the wrapped stage
+func (s *Stage) SetRand(r *rand.Rand) {
+	if seeded, ok := s.stage.(pipeline.Seeded); ok {
+		seeded.SetRand(r)
+	}
+}
+
+func (s *Stage) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.Now()
+}
+
+// Hits returns the number of jobs given a stored result
+func (s *Stage) Hits() uint64 {
+	return atomic.LoadUint64(&s.hits)
+}
+
+// Misses returns the number of jobs processed by the wrapped stage, including
+// those whose result had expired
+func (s *Stage) Misses() uint64 {
+	return atomic.LoadUint64(&s.misses)
+}
+
+// Expired returns the number of stored results found past their time to live
+func (s *Stage) Expired() uint64 {
+	return atomic.LoadUint64(&s.expired)
+}
+
+// Errors returns the number of results the store failed to keep
+func (s *Stage) Errors() uint64 {
+	return atomic.LoadUint64(&s.errors)
+}
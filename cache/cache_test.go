@@ -0,0 +1,232 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/cache"
+	"github.com/jboelter/pipeline/diskqueue"
+	"github.com/jboelter/pipeline/pipelinetest"
+)
+
+type file struct {
+	Path string
+	Hash string
+}
+
+/* test stage */
+type hashStage struct {
+	calls int32
+	fail  bool
+}
+
+func (s *hashStage) Name() string {
+	return "hash"
+}
+
+func (s *hashStage) Concurrency() int {
+	return 2
+}
+
+func (s *hashStage) Process(job interface{}) {
+	atomic.AddInt32(&s.calls, 1)
+	job.(*file).Hash = "#" + job.(*file).Path
+}
+
+/* test stage */
+type failingStage struct {
+	hashStage
+}
+
+func (s *failingStage) TryProcess(job interface{}) error {
+	atomic.AddInt32(&s.calls, 1)
+	return errors.New("failed")
+}
+
+func options() cache.Options {
+	return cache.Options{
+		Key: func(job interface{}) (string, bool) {
+			return job.(*file).Path, job.(*file).Path != ""
+		},
+		Result: func(job interface{}) interface{} {
+			return job.(*file).Hash
+		},
+		Apply: func(job, result interface{}) {
+			job.(*file).Hash = result.(string)
+		},
+	}
+}
+
+func files(paths ...string) []interface{} {
+	jobs := make([]interface{}, len(paths))
+	for i, p := range paths {
+		jobs[i] = &file{Path: p}
+	}
+	return jobs
+}
+
+func TestCache(t *testing.T) {
+	inner := &hashStage{}
+	stage := cache.Wrap(inner, cache.NewMemory(), options())
+	if stage.Name() != "hash" || stage.Concurrency() != 2 {
+		t.Errorf("expected the name and concurrency of the wrapped stage")
+	}
+
+	// a single worker so the second /a is a hit
+	result, err := pipelinetest.RunStage(stage, files("/a", "/b", "/a", ""), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, job := range result.Jobs {
+		if f := job.(*file); f.Hash != "#"+f.Path {
+			t.Errorf("unexpected hash %+v", f)
+		}
+	}
+	if inner.calls != 3 || stage.Hits() != 1 || stage.Misses() != 2 {
+		t.Errorf("expected 3 calls, 1 hit and 2 misses; got %v, %v, %v", inner.calls, stage.Hits(), stage.Misses())
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	opts := options()
+	opts.TTL = time.Hour
+
+	inner := &hashStage{}
+	stage := cache.Wrap(inner, cache.NewMemory(), opts)
+	stage.SetClock(clock)
+
+	stage.Process(&file{Path: "/a"})
+	clock.Advance(59 * time.Minute)
+	stage.Process(&file{Path: "/a"})
+	if inner.calls != 1 || stage.Hits() != 1 {
+		t.Errorf("expected a hit within the ttl")
+	}
+
+	clock.Advance(time.Minute)
+	stage.Process(&file{Path: "/a"})
+	if inner.calls != 2 || stage.Expired() != 1 {
+		t.Errorf("expected the result to expire")
+	}
+}
+
+func TestCacheFailure(t *testing.T) {
+	inner := &failingStage{}
+	memory := cache.NewMemory()
+	stage := cache.Wrap(inner, memory, options())
+
+	p := pipeline.New()
+	p.SetGenerator(pipelinetest.NewSliceGenerator(files("/a")...))
+	p.AddStageWithOptions(stage, pipeline.StageOptions{Retries: 2})
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	pipelinetest.AssertErrors(t, p.Snapshot().Stages[0], 1)
+	if inner.calls != 3 || memory.Len() != 0 {
+		t.Errorf("expected 3 attempts and nothing cached; got %v, %v", inner.calls, memory.Len())
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	codec := diskqueue.JSONCodec{New: func() interface{} { return new(string) }}
+	opts := options()
+	opts.Apply = func(job, result interface{}) {
+		job.(*file).Hash = *result.(*string)
+	}
+
+	store, err := cache.OpenFile(path, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := &hashStage{}
+	if _, err := pipelinetest.RunStage(cache.Wrap(inner, store, opts), files("/a", "/b"), 1); err != nil {
+		t.Fatal(err)
+	}
+	store.Delete("/b")
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn write from a crash is skipped
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"k":"/c`)
+	f.Close()
+
+	store, err = cache.OpenFile(path, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	inner = &hashStage{}
+	stage := cache.Wrap(inner, store, opts)
+	result, err := pipelinetest.RunStage(stage, files("/a", "/b"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.calls != 1 || stage.Hits() != 1 || result.Jobs[0].(*file).Hash != "#/a" {
+		t.Errorf("expected /a from the file and /b processed again; calls=%v, hits=%v", inner.calls, stage.Hits())
+	}
+	if store.Len() != 2 {
+		t.Errorf("expected 2 results, got %v", store.Len())
+	}
+}
+
+func TestFileCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	codec := diskqueue.JSONCodec{New: func() interface{} { return new(string) }}
+
+	store, err := cache.OpenFile(path, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		store.Put("a", "x", time.Unix(int64(i), 0))
+	}
+	store.Close()
+	before, _ := os.Stat(path)
+
+	store, err = cache.OpenFile(path, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	after, _ := os.Stat(path)
+
+	if after.Size() >= before.Size() {
+		t.Errorf("expected the log to be compacted; %v >= %v", after.Size(), before.Size())
+	}
+	if _, stored, ok := store.Get("a"); !ok || !stored.Equal(time.Unix(9, 0)) {
+		t.Errorf("expected the latest result to survive")
+	}
+}
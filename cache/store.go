@@ -0,0 +1,282 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jboelter/pipeline/diskqueue"
+)
+
+type entry struct {
+	result interface{}
+	stored time.Time
+}
+
+// Memory is a Store held in memory
+type Memory struct {
+	_       struct{}
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string]entry),
+	}
+}
+
+// Get implements Store
+func (m *Memory) Get(key string) (interface{}, time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	return e.result, e.stored, ok
+}
+
+// Put implements Store
+func (m *Memory) Put(key string, result interface{}, stored time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = entry{result: result, stored: stored}
+	return nil
+}
+
+// Delete implements Store
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// Len returns the number of results stored
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// File is a Store kept in memory and logged to a file on local disk, so the
+// results survive a restart. Results are encoded with a diskqueue.Codec. The
+// log is compacted when it is opened if most of it is superseded.
+type File struct {
+	_       struct{}
+	mu      sync.Mutex
+	path    string
+	codec   diskqueue.Codec
+	f       *os.File
+	w       *bufio.Writer
+	entries map[string]entry
+	records int
+}
+
+// record is a line of the log; a record without a value deletes the key
+type record struct {
+	Key    string `json:"k"`
+	Stored int64  `json:"t,omitempty"`
+	Value  []byte `json:"v,omitempty"`
+}
+
+// OpenFile opens, or creates, the store logged to the file at path
+func OpenFile(path string, codec diskqueue.Codec) (*File, error) {
+	s := &File{
+		path:    path,
+		codec:   codec,
+		entries: make(map[string]entry),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if s.records > 2*len(s.entries) {
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *File) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue // a torn write from a crash
+		}
+		s.records++
+		if r.Value == nil {
+			delete(s.entries, r.Key)
+			continue
+		}
+		result, err := s.codec.Decode(r.Value)
+		if err != nil {
+			return fmt.Errorf("cache: %v: %v", s.path, err)
+		}
+		s.entries[r.Key] = entry{result: result, stored: time.Unix(0, r.Stored)}
+	}
+	return scanner.Err()
+}
+
+func (s *File) open() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.f, s.w = f, bufio.NewWriter(f)
+
+	// end a torn line so the next record starts a line of its own
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			s.w.WriteString("\n")
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log with only the current results
+func (s *File) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	s.records = 0
+	for key, e := range s.entries {
+		if err := s.write(w, key, e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *File) write(w *bufio.Writer, key string, e entry) error {
+	r := record{Key: key}
+	if e.result != nil {
+		value, err := s.codec.Encode(e.result)
+		if err != nil {
+			return err
+		}
+		r.Stored, r.Value = e.stored.UnixNano(), value
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.records++
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// Get implements Store
+func (s *File) Get(key string) (interface{}, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	return e.result, e.stored, ok
+}
+
+// Put implements Store. A nil result cannot be stored.
+func (s *File) Put(key string, result interface{}, stored time.Time) error {
+	if result == nil {
+		return s.Delete(key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := entry{result: result, stored: stored}
+	if err := s.write(s.w, key, e); err != nil {
+		return err
+	}
+	s.entries[key] = e
+	return nil
+}
+
+// Delete implements Store
+func (s *File) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+	delete(s.entries, key)
+	return s.write(s.w, key, entry{})
+}
+
+// Len returns the number of results stored
+func (s *File) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Sync writes the buffered records and commits the file to stable storage
+func (s *File) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Close writes the buffered records and closes the file
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,245 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package cli runs pipelines built from a registry definition from the command
+// line. A binary registers its stages and generators and hands over to Main:
+//
+//	func main() {
+//		registry.RegisterStage("hash", newHash)
+//		registry.RegisterGenerator("localfs", newLocalFs)
+//		cli.Main(registry.Default)
+//	}
+//
+// Flags override environment variables which override the definition:
+//
+//	-config      PIPELINE_CONFIG      path to the definition
+//	-depth       PIPELINE_DEPTH       queue depth per worker
+//	-buffer      PIPELINE_BUFFER      buffer the queues between stages
+//	-concurrent  PIPELINE_CONCURRENT  run stages with their full concurrency
+//	-verbose     PIPELINE_VERBOSE     enable verbose logging
+//	-admin       PIPELINE_ADMIN       serve the admin handler on this address
+//	-dry-run                          validate and print the pipeline without creating the generator
+//
+// SIGINT and SIGTERM abort the generator so the jobs in flight can drain; a
+// summary of each stage is printed once the pipeline completes.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/admin"
+	"github.com/jboelter/pipeline/registry"
+)
+
+// Command holds the environment a pipeline is run in
+type Command struct {
+	_        struct{}
+	Registry *registry.Registry
+	Stdout   io.Writer
+	Stderr   io.Writer
+	Getenv   func(string) string
+	Signals  chan os.Signal // receives SIGINT and SIGTERM; created by Run when nil
+}
+
+// Main runs the command line with the registry and exits the process
+func Main(reg *registry.Registry) {
+	c := &Command{
+		Registry: reg,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+		Getenv:   os.Getenv,
+	}
+	os.Exit(c.Run(os.Args[0], os.Args[1:]))
+}
+
+// Run parses the arguments, builds the pipeline and runs it. It returns the
+// process exit code: 0 on success, 1 when the pipeline fails and 2 for usage
+// or definition errors.
+func (c *Command) Run(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.Stderr)
+
+	config := fs.String("config", c.Getenv("PIPELINE_CONFIG"), "path to the pipeline definition")
+	depth := fs.Int("depth", 0, "queue depth per worker")
+	buffer := fs.Bool("buffer", true, "buffer the queues between stages")
+	concurrent := fs.Bool("concurrent", true, "run stages with their full concurrency")
+	verbose := fs.Bool("verbose", false, "enable verbose logging")
+	addr := fs.String("admin", c.Getenv("PIPELINE_ADMIN"), "serve the admin handler on this address")
+	dryRun := fs.Bool("dry-run", false, "validate and print the pipeline without running it")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *config == "" {
+		fmt.Fprintln(c.Stderr, "cli: -config or PIPELINE_CONFIG is required")
+		fs.Usage()
+		return 2
+	}
+
+	def, err := c.Registry.ReadFile(*config)
+	if err != nil {
+		fmt.Fprintln(c.Stderr, err)
+		return 2
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if err := c.override(def, set, *depth, *buffer, *concurrent, *verbose); err != nil {
+		fmt.Fprintln(c.Stderr, err)
+		return 2
+	}
+
+	cfg := pipeline.DefaultConfig()
+	cfg.Logger = log.New(c.Stderr, "", log.LstdFlags)
+
+	// a dry-run does not create the generator, which may start work
+	if *dryRun {
+		p, err := c.Registry.Plan(def, cfg)
+		if err != nil {
+			fmt.Fprintln(c.Stderr, err)
+			return 2
+		}
+		fmt.Fprintf(c.Stdout, "%v: ok\n", describe(def, *config))
+		fmt.Fprint(c.Stdout, p.Mermaid())
+		return 0
+	}
+
+	p, err := c.Registry.Build(def, cfg)
+	if err != nil {
+		fmt.Fprintln(c.Stderr, err)
+		return 2
+	}
+
+	if *addr != "" {
+		l, err := net.Listen("tcp", *addr)
+		if err != nil {
+			fmt.Fprintln(c.Stderr, err)
+			return 2
+		}
+		defer l.Close()
+		go http.Serve(l, admin.NewHandler(p))
+	}
+
+	signals := c.Signals
+	if signals == nil {
+		signals = make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				cfg.Logger.Printf("source=cli, action=abort, signal='%v'", sig)
+				p.Abort()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	err = p.Run()
+	c.summary(p, describe(def, *config), time.Since(start))
+	if err != nil {
+		fmt.Fprintln(c.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// override applies the environment, then any flags set explicitly, on top of
+// the definition
+func (c *Command) override(def *registry.Definition, set map[string]bool, depth int, buffer, concurrent, verbose bool) error {
+	if v := c.Getenv("PIPELINE_DEPTH"); v != "" && !set["depth"] {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("cli: PIPELINE_DEPTH: %v", err)
+		}
+		depth, set["depth"] = n, true
+	}
+
+	for _, b := range []struct {
+		env, flag string
+		v         *bool
+	}{
+		{"PIPELINE_BUFFER", "buffer", &buffer},
+		{"PIPELINE_CONCURRENT", "concurrent", &concurrent},
+		{"PIPELINE_VERBOSE", "verbose", &verbose},
+	} {
+		if v := c.Getenv(b.env); v != "" && !set[b.flag] {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("cli: %v: %v", b.env, err)
+			}
+			*b.v, set[b.flag] = parsed, true
+		}
+	}
+
+	if set["depth"] {
+		def.Depth = &depth
+	}
+	if set["buffer"] {
+		def.Buffered = &buffer
+	}
+	if set["concurrent"] {
+		def.NoConcurrency = !concurrent
+	}
+	if set["verbose"] {
+		def.Verbose = verbose
+	}
+	return nil
+}
+
+func (c *Command) summary(p *pipeline.Pipeline, name string, elapsed time.Duration) {
+	fmt.Fprintf(c.Stdout, "%v: elapsed=%v\n", name, elapsed)
+
+	w := tabwriter.NewWriter(c.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "stage\tworkers\tprocessed\terrors\tdropped\tspilled\t")
+	for _, s := range p.Snapshot().Stages {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t\n", s.Name, s.Workers, s.Processed, s.Errors, s.Dropped, s.Spilled)
+	}
+	w.Flush()
+}
+
+func describe(def *registry.Definition, path string) string {
+	if def.Name != "" {
+		return def.Name
+	}
+	return path
+}
@@ -0,0 +1,185 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cli_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/cli"
+	"github.com/jboelter/pipeline/registry"
+)
+
+type generator struct {
+	n, limit int
+	quit     chan struct{}
+}
+
+func (g *generator) Name() string {
+	return "generator"
+}
+
+func (g *generator) Next() interface{} {
+	select {
+	case <-g.quit:
+		return nil
+	default:
+	}
+	g.n++
+	if g.limit > 0 && g.n > g.limit {
+		return nil
+	}
+	return g.n
+}
+
+func (g *generator) Abort() {
+	close(g.quit)
+}
+
+type stage struct {
+	started chan struct{}
+}
+
+func (s *stage) Name() string {
+	return "stage"
+}
+
+func (s *stage) Concurrency() int {
+	return 2
+}
+
+func (s *stage) Process(interface{}) {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+}
+
+func setup(t *testing.T, definition string) (*cli.Command, string, *bytes.Buffer, map[string]string) {
+	dir, err := ioutil.TempDir("", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "pipeline.json")
+	if err := ioutil.WriteFile(path, []byte(definition), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &stage{started: make(chan struct{}, 1)}
+	reg := registry.New()
+	reg.RegisterGenerator("generator", func(params registry.Params) (pipeline.Generator, error) {
+		limit, err := params.Int("limit", 0)
+		return &generator{limit: limit, quit: make(chan struct{})}, err
+	})
+	reg.RegisterStage("stage", func(registry.Params) (pipeline.Stage, error) {
+		return s, nil
+	})
+
+	env := make(map[string]string)
+	stdout := &bytes.Buffer{}
+	c := &cli.Command{
+		Registry: reg,
+		Stdout:   stdout,
+		Stderr:   ioutil.Discard,
+		Getenv:   func(key string) string { return env[key] },
+	}
+	return c, path, stdout, env
+}
+
+func TestDryRun(t *testing.T) {
+	c, path, stdout, env := setup(t, `{"name": "test", "generator": {"type": "generator"}, "stages": [{"type": "stage"}]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c.Registry.RegisterGenerator("generator", func(registry.Params) (pipeline.Generator, error) {
+		t.Errorf("expected a dry-run not to create the generator")
+		return &generator{quit: make(chan struct{})}, nil
+	})
+
+	env["PIPELINE_DEPTH"] = "3"
+	if code := c.Run("test", []string{"-config", path, "-dry-run"}); code != 0 {
+		t.Fatalf("expected exit code 0, got %v", code)
+	}
+	if !strings.HasPrefix(stdout.String(), "test: ok\nflowchart LR") {
+		t.Errorf("unexpected output %v", stdout.String())
+	}
+
+	// the flag overrides the environment which overrides the definition
+	stdout.Reset()
+	c.Run("test", []string{"-config", path, "-dry-run", "-concurrent=false"})
+	if !strings.Contains(stdout.String(), "concurrency=1") {
+		t.Errorf("expected -concurrent=false to apply, got %v", stdout.String())
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	c, path, _, env := setup(t, `{"generator": {"type": "generator"}, "stages": [{"type": "missing"}]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if code := c.Run("test", nil); code != 2 {
+		t.Errorf("expected exit code 2 without a config, got %v", code)
+	}
+	if code := c.Run("test", []string{"-config", path, "-dry-run"}); code != 2 {
+		t.Errorf("expected exit code 2 for an unknown stage, got %v", code)
+	}
+
+	env["PIPELINE_CONFIG"] = path
+	env["PIPELINE_VERBOSE"] = "maybe"
+	if code := c.Run("test", []string{"-dry-run"}); code != 2 {
+		t.Errorf("expected exit code 2 for a bad environment variable, got %v", code)
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	c, path, stdout, _ := setup(t, `{"name": "test", "generator": {"type": "generator", "params": {"limit": 5}}, "stages": [{"type": "stage"}]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if code := c.Run("test", []string{"-config", path}); code != 0 {
+		t.Fatalf("expected exit code 0, got %v", code)
+	}
+
+	lines := strings.Split(stdout.String(), "\n")
+	if !strings.HasPrefix(lines[0], "test: elapsed=") || len(lines) < 3 {
+		t.Fatalf("unexpected summary %v", stdout.String())
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "stage" || fields[2] != "5" {
+		t.Errorf("expected 5 jobs processed, got %v", lines[2])
+	}
+}
+
+func TestSignalAborts(t *testing.T) {
+	c, path, _, _ := setup(t, `{"generator": {"type": "generator"}, "stages": [{"type": "stage"}]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c.Signals = make(chan os.Signal, 1)
+	c.Signals <- os.Interrupt
+
+	// the generator never ends on its own
+	if code := c.Run("test", []string{"-config", path}); code != 0 {
+		t.Errorf("expected exit code 0, got %v", code)
+	}
+}
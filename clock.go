@@ -0,0 +1,263 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the pipeline and for stages that implement
+// Clocked. Replacing the SystemClock with a VirtualClock lets tests step time
+// rather than wait for it.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the Clock equivalent of a time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Clocked may be implemented by a Stage or Generator that keeps time. The
+// pipeline calls SetClock with Config.Clock before Run or Simulate starts.
+type Clocked interface {
+	SetClock(Clock)
+}
+
+// Seeded may be implemented by a Stage or Generator that uses random numbers.
+// When Config.Seed is non-zero, or during Simulate, the pipeline calls SetRand
+// with a source derived from the seed. The source is safe for concurrent use.
+type Seeded interface {
+	SetRand(*rand.Rand)
+}
+
+// SystemClock is the Clock backed by the time package
+type SystemClock struct{}
+
+// Now implements Clock
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep implements Clock
+func (SystemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// NewTimer implements Clock
+func (SystemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// VirtualClock is a Clock that only moves when told to. Sleep and timers wait
+// until Advance, Step or Set moves the clock past their deadline.
+//
+// During Simulate the clock is driven by the pipeline: time moves forward as
+// soon as a step sleeps or creates a timer, since nothing else can run.
+type VirtualClock struct {
+	_       struct{}
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	pending []*virtualTimer
+	auto    bool
+}
+
+type virtualTimer struct {
+	clock *VirtualClock
+	when  time.Time
+	c     chan time.Time
+}
+
+// NewVirtualClock creates a VirtualClock set to start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	c := &VirtualClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now implements Clock
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep implements Clock
+func (c *VirtualClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+// NewTimer implements Clock
+func (c *VirtualClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &virtualTimer{clock: c, c: make(chan time.Time, 1), when: c.now.Add(d)}
+	if c.auto && t.when.After(c.now) {
+		c.now = t.when
+	}
+
+	if !t.when.After(c.now) {
+		t.c <- c.now
+		return t
+	}
+	c.pending = append(c.pending, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by d, firing any timers that come due
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to t, firing any timers that come due. The clock never
+// moves backwards outside of Simulate.
+func (c *VirtualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.set(t)
+	}
+}
+
+// Step moves the clock to the earliest pending timer and fires it. It returns
+// false if there are no pending timers.
+func (c *VirtualClock) Step() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return false
+	}
+	earliest := c.pending[0].when
+	for _, t := range c.pending[1:] {
+		if t.when.Before(earliest) {
+			earliest = t.when
+		}
+	}
+	c.set(earliest)
+	return true
+}
+
+// Waiters returns the number of pending timers, including goroutines blocked
+// in Sleep
+func (c *VirtualClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// BlockUntil waits until at least n timers are pending; use it to know that
+// the goroutines under test have reached a Sleep before advancing the clock
+func (c *VirtualClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) < n {
+		c.cond.Wait()
+	}
+}
+
+// set moves the clock to t and fires due timers in deadline order; c.mu must
+// be held
+func (c *VirtualClock) set(t time.Time) {
+	c.now = t
+
+	sort.SliceStable(c.pending, func(i, j int) bool {
+		return c.pending[i].when.Before(c.pending[j].when)
+	})
+	n := 0
+	for n < len(c.pending) && !c.pending[n].when.After(t) {
+		c.pending[n].c <- c.pending[n].when
+		n++
+	}
+	c.pending = append(c.pending[:0], c.pending[n:]...)
+	c.cond.Broadcast()
+}
+
+// jump sets the clock for the next simulated step, which may be earlier than
+// the previous step finished
+func (c *VirtualClock) jump(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func (t *virtualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *virtualTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.pending {
+		if p == t {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// lockedSource makes a rand.Source safe for concurrent use
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func newRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed)})
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
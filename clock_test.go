@@ -0,0 +1,138 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := pipeline.NewVirtualClock(start)
+
+	a := c.NewTimer(time.Second)
+	b := c.NewTimer(3 * time.Second)
+	stopped := c.NewTimer(2 * time.Second)
+
+	if c.Waiters() != 3 {
+		t.Errorf("expected 3 waiters, got %v", c.Waiters())
+	}
+	if !stopped.Stop() || stopped.Stop() {
+		t.Errorf("expected Stop to succeed once")
+	}
+
+	c.Advance(1500 * time.Millisecond)
+	select {
+	case when := <-a.C():
+		if !when.Equal(start.Add(time.Second)) {
+			t.Errorf("expected the timer to fire at its deadline, got %v", when)
+		}
+	default:
+		t.Errorf("expected the first timer to fire")
+	}
+
+	if !c.Step() || !c.Now().Equal(start.Add(3*time.Second)) {
+		t.Errorf("expected Step to move to the last timer, now=%v", c.Now())
+	}
+	<-b.C()
+
+	if c.Step() {
+		t.Errorf("expected no pending timers")
+	}
+}
+
+func TestVirtualClockSleep(t *testing.T) {
+	c := pipeline.NewVirtualClock(time.Unix(0, 0))
+
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Hour)
+		close(done)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Hour)
+	<-done
+}
+
+func TestVirtualClockTimeout(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	sleeper := &SleepingStage{Sleep: time.Hour}
+	counting := &CountingStage{}
+	p.AddStageWithOptions(sleeper, pipeline.StageOptions{Timeout: time.Minute})
+	p.AddStage(counting)
+
+	done := make(chan error)
+	go func() {
+		done <- p.Run()
+	}()
+
+	// every job waits on both the stage sleep and the timeout; step the clock
+	// past each timeout, then past the sleep the worker waits out, without
+	// waiting an hour of wall time
+	for i := uint64(1); i <= 10; i++ {
+		clock.BlockUntil(2)
+		clock.Advance(time.Minute)
+		for p.Snapshot().Stages[0].Errors != i {
+		}
+		clock.Advance(time.Hour)
+	}
+	if err := <-done; err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	if errs := p.Snapshot().Stages[0].Errors; errs != 10 || counting.ProcessCount != 0 {
+		t.Errorf("expected 10 timeouts; errors=%v, processed=%v", errs, counting.ProcessCount)
+	}
+}
+
+/* test stage */
+type SleepingStage struct {
+	Sleep time.Duration
+	clock pipeline.Clock
+}
+
+func (s *SleepingStage) SetClock(c pipeline.Clock) {
+	s.clock = c
+}
+
+func (s *SleepingStage) Name() string {
+	return "SleepingStage"
+}
+
+func (s *SleepingStage) Concurrency() int {
+	return 1
+}
+
+func (s *SleepingStage) Process(interface{}) {
+	s.clock.Sleep(s.Sleep)
+}
@@ -0,0 +1,119 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+// Composite is a sequence of stages packaged as a single Stage so that it can
+// be reused across pipelines. Adding a Composite to a Pipeline adds each of its
+// stages in turn, each with its own workers, queue and stats, under the name
+// "composite/stage". Of the options the Composite is added with, Middleware
+// wraps each of its stages and When selects the jobs for all of them, so that
+// the others bypass the whole Composite. The other options are set per stage
+// with AddStageWithOptions; setting them on the Composite panics.
+type Composite struct {
+	_       struct{}
+	name    string
+	stages  []Stage
+	options []StageOptions
+}
+
+// Compose returns a Composite of the stages, in order
+func Compose(name string, stages ...Stage) *Composite {
+	c := &Composite{name: name}
+	c.AddStage(stages...)
+	return c
+}
+
+// AddStage adds 1 or more stages to the end of the Composite
+func (c *Composite) AddStage(stages ...Stage) {
+	for _, s := range stages {
+		c.AddStageWithOptions(s, StageOptions{})
+	}
+}
+
+// AddStageWithOptions adds a stage to the end of the Composite with options, as
+// for Pipeline.AddStageWithOptions
+func (c *Composite) AddStageWithOptions(s Stage, opts StageOptions) {
+	c.stages = append(c.stages, s)
+	c.options = append(c.options, opts)
+}
+
+// Name returns the name of the Composite
+func (c *Composite) Name() string {
+	return c.name
+}
+
+// Concurrency returns 1; each stage of the Composite has its own concurrency
+func (c *Composite) Concurrency() int {
+	return 1
+}
+
+// Stages returns the stages of the Composite, in order
+func (c *Composite) Stages() []Stage {
+	return append([]Stage(nil), c.stages...)
+}
+
+// Process passes the job through the Process of each stage in turn. A Pipeline
+// does not call it; it is for running a Composite on its own.
+func (c *Composite) Process(job interface{}) {
+	for _, s := range c.stages {
+		s.Process(job)
+	}
+}
+
+// expand adds the stages to a pipeline, renamed under prefix and wrapped in
+// the middlewares, descending into nested composites
+func (c *Composite) expand(prefix string, outer StageOptions, add func(Stage, StageOptions)) {
+	if outer.Concurrency != 0 || outer.Retries != 0 || outer.RetryDelay != 0 || outer.Timeout != 0 || outer.Partitioned || outer.Key != nil {
+		panic("pipeline: only Middleware and When apply to a Composite; set the other options on its stages")
+	}
+
+	for i, s := range c.stages {
+		opts := c.options[i]
+		opts.Middleware = append(append([]Middleware(nil), outer.Middleware...), opts.Middleware...)
+		opts.When = both(outer.When, opts.When)
+
+		if inner, ok := s.(*Composite); ok {
+			inner.expand(prefix+"/"+inner.name, opts, add)
+			continue
+		}
+		add(intercept(s, proceed, prefix+"/"+s.Name()), opts)
+	}
+}
+
+// both returns a predicate that holds when a and b, if set, both hold
+func both(a, b func(job interface{}) bool) func(job interface{}) bool {
+	if a == nil || b == nil {
+		if a == nil {
+			return b
+		}
+		return a
+	}
+	return func(job interface{}) bool {
+		return a(job) && b(job)
+	}
+}
+
+// proceed is an Interceptor that only processes the job
+func proceed(inv *Invocation) error {
+	return inv.Proceed()
+}
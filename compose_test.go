@@ -0,0 +1,165 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/jboelter/pipeline"
+)
+
+func TestComposite(t *testing.T) {
+	flaky := &FlakyStage{Failures: 1}
+	counting := &CountingStage{}
+	ingest := pipeline.Compose("ingest")
+	ingest.AddStageWithOptions(flaky, pipeline.StageOptions{Retries: 1, Concurrency: 3})
+	ingest.AddStage(counting)
+
+	var mu sync.Mutex
+	var trace []string
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStageWithOptions(ingest, pipeline.StageOptions{Middleware: []pipeline.Middleware{tracing("outer", &mu, &trace)}})
+	p.AddStage(&CountingStage{})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	// the inner stages keep their own workers and counts
+	snap := p.Snapshot()
+	if len(snap.Stages) != 3 {
+		t.Fatalf("expected 3 stages, got %v", len(snap.Stages))
+	}
+	if ss := snap.Stages[0]; ss.Name != "ingest/FlakyStage" || ss.Workers != 3 || ss.Processed != 10 || ss.Errors != 0 {
+		t.Errorf("unexpected stage snapshot %+v", ss)
+	}
+	if ss := snap.Stages[1]; ss.Name != "ingest/CountingStage" || ss.Processed != 10 {
+		t.Errorf("unexpected stage snapshot %+v", ss)
+	}
+	if snap.Stages[2].Name != "CountingStage" {
+		t.Errorf("unexpected stage name %v", snap.Stages[2].Name)
+	}
+
+	// the retries of the inner stage are kept
+	if flaky.Attempts() != 20 || counting.ProcessCount != 10 {
+		t.Errorf("expected 20 attempts and 10 jobs; attempts=%v, processed=%v", flaky.Attempts(), counting.ProcessCount)
+	}
+
+	// the middleware wraps each inner stage, once per attempt
+	if len(trace) != 60 {
+		t.Errorf("expected 60 trace entries, got %v", len(trace))
+	}
+}
+
+func TestCompositeNested(t *testing.T) {
+	ingest := func() *pipeline.Composite {
+		parse := pipeline.Compose("parse", &CountingStage{}, &CountingStage{})
+		return pipeline.Compose("ingest", &CountingStage{}, parse)
+	}
+
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(ingest(), ingest())
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	names := []string{
+		"ingest/CountingStage",
+		"ingest/parse/CountingStage",
+		"ingest/parse/CountingStage",
+	}
+	stages := p.Snapshot().Stages
+	if len(stages) != 2*len(names) {
+		t.Fatalf("expected %v stages, got %v", 2*len(names), len(stages))
+	}
+	for i, ss := range stages {
+		if ss.Name != names[i%len(names)] || ss.Processed != 10 {
+			t.Errorf("unexpected stage snapshot %+v", ss)
+		}
+	}
+}
+
+func TestCompositeWhen(t *testing.T) {
+	a, b := &CountingStage{}, &CountingStage{}
+	ingest := pipeline.Compose("ingest", a)
+	ingest.AddStageWithOptions(b, pipeline.StageOptions{When: func(job interface{}) bool { return job.(int) <= 4 }})
+
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStageWithOptions(ingest, pipeline.StageOptions{When: even})
+
+	jobs, err := p.Collect()
+	if err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	// the odd jobs bypass the whole composite
+	if len(jobs) != 10 || a.ProcessCount != 5 || b.ProcessCount != 2 {
+		t.Errorf("expected 10 jobs, 5 and 2 processed; got %v, %v and %v", len(jobs), a.ProcessCount, b.ProcessCount)
+	}
+	snap := p.Snapshot()
+	if snap.Stages[0].Skipped != 5 || snap.Stages[1].Skipped != 8 {
+		t.Errorf("expected 5 and 8 skipped, got %v and %v", snap.Stages[0].Skipped, snap.Stages[1].Skipped)
+	}
+}
+
+func TestCompositeOptions(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected options other than Middleware and When to panic")
+		}
+	}()
+
+	p := pipeline.New()
+	p.AddStageWithOptions(pipeline.Compose("ingest", &CountingStage{}), pipeline.StageOptions{Concurrency: 4})
+}
+
+func TestCompositeResize(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(pipeline.Compose("ingest", &CountingStage{}))
+
+	if err := p.Resize("ingest", 2); err != pipeline.ErrNoStage {
+		t.Errorf("expected ErrNoStage, got %v", err)
+	}
+	if err := p.Resize("ingest/CountingStage", 2); err != pipeline.ErrNotRunning {
+		t.Errorf("expected ErrNotRunning, got %v", err)
+	}
+}
+
+func TestCompositeProcess(t *testing.T) {
+	a, b := &CountingStage{}, &CountingStage{}
+	c := pipeline.Compose("ingest", a, b)
+	c.Process(1)
+
+	if a.ProcessCount != 1 || b.ProcessCount != 1 {
+		t.Errorf("expected each stage to process the job once")
+	}
+	if c.Name() != "ingest" || len(c.Stages()) != 2 {
+		t.Errorf("unexpected composite %v with %v stages", c.Name(), len(c.Stages()))
+	}
+}
@@ -0,0 +1,122 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package dedup provides a stage that drops jobs whose key has been seen
+// before, with pluggable sets to remember the keys: an in-memory LRU with an
+// optional time to live, a Bloom filter for very large key spaces and an
+// on-disk set that persists across runs.
+//
+//	seen := dedup.NewLRU(100000, time.Hour)
+//	p.AddStage(dedup.New(seen, dedup.Options{}))
+package dedup
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jboelter/pipeline"
+)
+
+// Set remembers keys. Seen records key and reports whether it had been
+// recorded before; it must be safe for concurrent use.
+type Set interface {
+	Seen(key string) bool
+}
+
+// Options configures a dedup stage. Key returns the key of a job; by default
+// a job that implements pipeline.Keyed is keyed by it and any other job by its
+// fmt.Sprint form. Concurrency defaults to 1.
+type Options struct {
+	_           struct{}
+	Name        string
+	Key         func(job interface{}) string
+	Concurrency int
+}
+
+// Stage drops jobs whose key is in the set and passes the others on
+type Stage struct {
+	_          struct{}
+	set        Set
+	opts       Options
+	duplicates uint64
+}
+
+// New returns a stage that drops the jobs already seen by set
+func New(set Set, opts Options) *Stage {
+	if opts.Name == "" {
+		opts.Name = "Dedup"
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.Key == nil {
+		opts.Key = func(job interface{}) string {
+			if k, ok := job.(pipeline.Keyed); ok {
+				return k.Key()
+			}
+			return fmt.Sprint(job)
+		}
+	}
+
+	return &Stage{
+		set:  set,
+		opts: opts,
+	}
+}
+
+// Name implements pipeline.Stage
+func (s *Stage) Name() string {
+	return s.opts.Name
+}
+
+// Concurrency implements pipeline.Stage
+func (s *Stage) Concurrency() int {
+	return s.opts.Concurrency
+}
+
+// Process implements pipeline.Stage. The pipeline calls Emit instead; Process
+// only records the key.
+func (s *Stage) Process(job interface{}) {
+	s.Emit(job, func(interface{}) {})
+}
+
+// Emit implements pipeline.Emitter, passing the job on unless it is a
+// duplicate
+func (s *Stage) Emit(job interface{}, emit func(interface{})) {
+	if s.set.Seen(s.opts.Key(job)) {
+		atomic.AddUint64(&s.duplicates, 1)
+		return
+	}
+	emit(job)
+}
+
+// SetClock implements pipeline.Clocked, handing the clock to the set
+func (s *Stage) SetClock(c pipeline.Clock) {
+	if clocked, ok := s.set.(pipeline.Clocked); ok {
+		clocked.SetClock(c)
+	}
+}
+
+// Duplicates returns the number of jobs dropped
+func (s *Stage) Duplicates() uint64 {
+	return atomic.LoadUint64(&s.duplicates)
+}
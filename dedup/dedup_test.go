@@ -0,0 +1,205 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dedup_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/dedup"
+	"github.com/jboelter/pipeline/pipelinetest"
+)
+
+func TestStage(t *testing.T) {
+	stage := dedup.New(dedup.NewLRU(10, 0), dedup.Options{})
+
+	result, err := pipelinetest.RunStage(stage, []interface{}{"a", "b", "a", "c", "b"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{"a", "b", "c"})
+	if stage.Duplicates() != 2 {
+		t.Errorf("expected 2 duplicates, got %v", stage.Duplicates())
+	}
+}
+
+type file struct {
+	path string
+	size int
+}
+
+func (f *file) Key() string {
+	return f.path
+}
+
+func TestStageKey(t *testing.T) {
+	a, b, a2 := &file{"/a", 1}, &file{"/b", 2}, &file{"/a", 3}
+
+	// pipeline.Keyed by default
+	result, err := pipelinetest.RunStage(dedup.New(dedup.NewLRU(10, 0), dedup.Options{}), []interface{}{a, b, a2}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{a, b})
+
+	// or a key function
+	opts := dedup.Options{Key: func(job interface{}) string { return strconv.Itoa(job.(*file).size % 2) }}
+	result, err = pipelinetest.RunStage(dedup.New(dedup.NewLRU(10, 0), opts), []interface{}{a, b, a2}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{a, b})
+}
+
+func TestLRUEvicts(t *testing.T) {
+	lru := dedup.NewLRU(2, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		if lru.Seen(key) {
+			t.Errorf("%v should not have been seen", key)
+		}
+	}
+	if lru.Len() != 2 {
+		t.Errorf("expected 2 keys, got %v", lru.Len())
+	}
+	if lru.Seen("a") {
+		t.Errorf("a should have been evicted")
+	}
+	if !lru.Seen("c") {
+		t.Errorf("c should have been seen")
+	}
+}
+
+func TestLRUTTL(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	lru := dedup.NewLRU(10, time.Minute)
+	lru.SetClock(clock)
+
+	lru.Seen("a")
+	clock.Advance(59 * time.Second)
+	if !lru.Seen("a") {
+		t.Errorf("a should have been seen")
+	}
+
+	// seeing a key restarts its time to live
+	clock.Advance(59 * time.Second)
+	if !lru.Seen("a") {
+		t.Errorf("a should have been seen")
+	}
+
+	clock.Advance(time.Minute)
+	if lru.Seen("a") {
+		t.Errorf("a should have expired")
+	}
+}
+
+func TestStageClock(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	lru := dedup.NewLRU(10, time.Minute)
+
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(pipelinetest.NewSliceGenerator("a"))
+	p.AddStage(dedup.New(lru, dedup.Options{}))
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if lru.Seen("a") {
+		t.Errorf("the set should keep time with the pipeline clock")
+	}
+}
+
+func TestBloom(t *testing.T) {
+	bloom := dedup.NewBloom(10000, 0.01)
+
+	for i := 0; i < 5000; i++ {
+		bloom.Seen(strconv.Itoa(i))
+	}
+	for i := 0; i < 5000; i++ {
+		if !bloom.Seen(strconv.Itoa(i)) {
+			t.Fatalf("%v should have been seen", i)
+		}
+	}
+
+	positives := 0
+	for i := 5000; i < 6000; i++ {
+		if bloom.Seen(strconv.Itoa(i)) {
+			positives++
+		}
+	}
+	if positives > 20 {
+		t.Errorf("too many false positives: %v of 1000", positives)
+	}
+}
+
+func TestDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen")
+
+	d, err := dedup.OpenDisk(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage := dedup.New(d, dedup.Options{})
+	result, err := pipelinetest.RunStage(stage, []interface{}{"a", "b\nc", "a"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{"a", "b\nc"})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn write from a crash is skipped
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`"tor`)
+	f.Close()
+
+	// the next run remembers the keys
+	d, err = dedup.OpenDisk(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = pipelinetest.RunStage(dedup.New(d, dedup.Options{}), []interface{}{"b\nc", "d", "a"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{"d"})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = dedup.OpenDisk(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if d.Len() != 3 {
+		t.Errorf("expected 3 keys, got %v", d.Len())
+	}
+}
@@ -0,0 +1,140 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dedup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Disk is a Set that appends each new key to a file and loads the file when
+// opened, so that the keys seen by one run are remembered by the next. It
+// holds every key in memory.
+type Disk struct {
+	_    struct{}
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	keys map[string]struct{}
+	err  error
+}
+
+// OpenDisk opens, or creates, the set stored in the file at path
+func OpenDisk(path string) (*Disk, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Disk{
+		f:    f,
+		w:    bufio.NewWriter(f),
+		keys: make(map[string]struct{}),
+	}
+
+	// each line holds a quoted key; a torn last line from a crash is ignored
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		key, err := strconv.Unquote(scanner.Text())
+		if err != nil {
+			continue
+		}
+		d.keys[key] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("dedup: %v: %v", path, err)
+	}
+
+	// end a torn line so the next key starts a line of its own
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			d.w.WriteString("\n")
+		}
+	}
+	return d, nil
+}
+
+// Seen implements Set. A key that cannot be written is still remembered for
+// this run; the error is reported by Err and by Close.
+func (d *Disk) Seen(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.keys[key]; ok {
+		return true
+	}
+	d.keys[key] = struct{}{}
+
+	if d.err == nil {
+		_, d.err = d.w.WriteString(strconv.Quote(key) + "\n")
+	}
+	return false
+}
+
+// Len returns the number of keys held
+func (d *Disk) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.keys)
+}
+
+// Sync writes the buffered keys to the file and commits it to stable storage
+func (d *Disk) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.flush(); err != nil {
+		return err
+	}
+	return d.f.Sync()
+}
+
+// Err returns the first error writing a key
+func (d *Disk) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// Close writes the buffered keys and closes the file
+func (d *Disk) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.flush()
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d *Disk) flush() error {
+	if d.err == nil {
+		d.err = d.w.Flush()
+	}
+	return d.err
+}
@@ -0,0 +1,156 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dedup
+
+import (
+	"container/list"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+// LRU is a Set holding the most recently seen keys in memory. A key is
+// forgotten once size newer keys have been seen or, when ttl is greater than
+// 0, once it has not been seen for ttl.
+type LRU struct {
+	_     struct{}
+	size  int
+	ttl   time.Duration
+	mu    sync.Mutex
+	clock pipeline.Clock
+	order *list.List // of *entry, the most recent first
+	keys  map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	seen time.Time
+}
+
+// NewLRU returns an LRU holding up to size keys
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		clock: pipeline.SystemClock{},
+		order: list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+}
+
+// Seen implements Set. Seeing a key again makes it the most recent and
+// restarts its time to live.
+func (l *LRU) Seen(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if e, ok := l.keys[key]; ok {
+		ent := e.Value.(*entry)
+		fresh := l.ttl <= 0 || now.Sub(ent.seen) < l.ttl
+		ent.seen = now
+		l.order.MoveToFront(e)
+		return fresh
+	}
+
+	l.keys[key] = l.order.PushFront(&entry{key: key, seen: now})
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+	return false
+}
+
+// Len returns the number of keys held, including any that have expired but
+// not yet been evicted
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+// SetClock implements pipeline.Clocked
+func (l *LRU) SetClock(c pipeline.Clock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = c
+}
+
+func (l *LRU) remove(e *list.Element) {
+	l.order.Remove(e)
+	delete(l.keys, e.Value.(*entry).key)
+}
+
+// Bloom is a Set backed by a Bloom filter. It uses a fixed amount of memory
+// however many keys are seen, at the cost of false positives: a key not seen
+// before is reported as seen, and its job dropped, with probability up to the
+// rate it was sized for. It never forgets a key.
+type Bloom struct {
+	_    struct{}
+	mu   sync.Mutex
+	bits []uint64
+	m, k uint64
+}
+
+// NewBloom returns a Bloom filter sized for n keys with a false positive
+// rate of p
+func NewBloom(n int, p float64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &Bloom{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Seen implements Set
+func (b *Bloom) Seen(key string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seen := true
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if b.bits[word]&mask == 0 {
+			seen = false
+			b.bits[word] |= mask
+		}
+	}
+	return seen
+}
@@ -0,0 +1,77 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package diskqueue
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec converts jobs to and from the bytes stored in a segment
+type Codec interface {
+	Encode(job interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// GobCodec encodes jobs with encoding/gob. The concrete job types must be
+// registered with gob.Register.
+type GobCodec struct{}
+
+// Encode implements Codec
+func (GobCodec) Encode(job interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&job); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements Codec
+func (GobCodec) Decode(data []byte) (interface{}, error) {
+	var job interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// JSONCodec encodes jobs with encoding/json. New returns the value to decode
+// into, typically a pointer to a new job.
+type JSONCodec struct {
+	New func() interface{}
+}
+
+// Encode implements Codec
+func (c JSONCodec) Encode(job interface{}) ([]byte, error) {
+	return json.Marshal(job)
+}
+
+// Decode implements Codec
+func (c JSONCodec) Decode(data []byte) (interface{}, error) {
+	job := c.New()
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
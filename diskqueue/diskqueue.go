@@ -0,0 +1,546 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package diskqueue provides a persistent pipeline.Queue backed by an
+// append-only segment log on local disk.
+//
+// Every job Put on the queue is encoded with a Codec and appended to the
+// active segment. When the consuming stage has finished with a job the
+// pipeline acknowledges it and an ack record is appended. Segments whose jobs
+// have all been acknowledged are removed. Opening a queue over an existing
+// directory replays the log so jobs that were never acknowledged, including
+// those in flight when the process stopped, are delivered again.
+//
+// A pipeline.Envelope is stored as its job, encoded with the Codec, and its
+// pipeline.Metadata, encoded as JSON; the attributes are read back as the
+// values encoding/json decodes them to.
+package diskqueue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/jboelter/pipeline"
+)
+
+// ErrClosed is returned by Put once the queue has been closed.
+var ErrClosed = errors.New("diskqueue: the queue is closed")
+
+// ErrCorrupt is returned by Open when a segment other than the most recent one
+// cannot be read back.
+var ErrCorrupt = errors.New("diskqueue: corrupt segment")
+
+const (
+	kindJob      byte = 1
+	kindAck      byte = 2
+	kindEnvelope byte = 3 // a job with its metadata
+
+	headerSize = 8     // length + crc32
+	bodySize   = 1 + 8 // kind + id
+	suffix     = ".seg"
+)
+
+// Options defines the configuration for a Queue
+type Options struct {
+	_              struct{}
+	Codec          Codec
+	MaxSegmentSize int64
+	Sync           bool
+}
+
+// DefaultOptions provides a default configuration using the GobCodec and
+// 64MiB segments without an fsync on each write
+func DefaultOptions() Options {
+	return Options{
+		Codec:          GobCodec{},
+		MaxSegmentSize: 64 << 20,
+	}
+}
+
+// Queue is a durable pipeline.Queue. It is safe for concurrent use.
+type Queue struct {
+	_     struct{}
+	dir   string
+	opts  Options
+	mu    sync.Mutex
+	cond  *sync.Cond
+	segs  []*segment
+	next  uint64
+	err   error
+	ready []*record
+
+	// delivered, unacknowledged jobs
+	byKey map[interface{}][]*record
+	loose []looseRecord
+
+	closed   bool
+	released bool
+}
+
+type segment struct {
+	seq  uint64
+	f    *os.File
+	size int64
+	live int // jobs in this segment that have not been acknowledged
+}
+
+type record struct {
+	id  uint64
+	seg *segment
+	off int64 // offset of the payload
+	n   int
+	env bool // the payload is an envelope
+}
+
+type looseRecord struct {
+	job interface{}
+	r   *record
+}
+
+// Open opens, or creates, the queue stored in dir. Unacknowledged jobs found
+// in the directory are queued for delivery ahead of any new jobs.
+func Open(dir string, opts Options) (*Queue, error) {
+	if opts.Codec == nil {
+		opts.Codec = GobCodec{}
+	}
+	if opts.MaxSegmentSize <= 0 {
+		opts.MaxSegmentSize = DefaultOptions().MaxSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		dir:   dir,
+		opts:  opts,
+		byKey: make(map[interface{}][]*record),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	if err := q.replay(); err != nil {
+		q.closeFiles()
+		return nil, err
+	}
+
+	if err := q.rotate(); err != nil {
+		q.closeFiles()
+		return nil, err
+	}
+	q.compact()
+
+	return q, nil
+}
+
+// Put appends the job to the log and queues it for delivery
+func (q *Queue) Put(job interface{}) error {
+	kind := kindJob
+	var data []byte
+	var err error
+	if e, ok := job.(*pipeline.Envelope); ok {
+		kind = kindEnvelope
+		data, err = q.encodeEnvelope(e)
+	} else {
+		data, err = q.opts.Codec.Encode(job)
+	}
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	r, err := q.write(kind, q.next, data)
+	if err != nil {
+		return err
+	}
+	r.env = kind == kindEnvelope
+	q.next++
+	r.seg.live++
+	q.ready = append(q.ready, r)
+	q.cond.Signal()
+	return nil
+}
+
+// Get returns the oldest undelivered job, blocking until one is available.
+// It returns false once the queue is closed and no jobs remain. A job that
+// cannot be read back is acknowledged and skipped; the error is reported by
+// Err.
+func (q *Queue) Get() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for len(q.ready) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.ready) == 0 {
+			q.release()
+			return nil, false
+		}
+
+		r := q.ready[0]
+		q.ready[0] = nil
+		q.ready = q.ready[1:]
+
+		job, err := q.read(r)
+		if err != nil {
+			q.err = err
+			q.ack(r)
+			continue
+		}
+
+		if comparable(job) {
+			q.byKey[job] = append(q.byKey[job], r)
+		} else {
+			q.loose = append(q.loose, looseRecord{job: job, r: r})
+		}
+		return job, true
+	}
+}
+
+// Ack records that the job returned by Get has been fully processed and will
+// not be delivered again
+func (q *Queue) Ack(job interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var r *record
+	if comparable(job) {
+		rs := q.byKey[job]
+		if len(rs) == 0 {
+			return
+		}
+		r = rs[0]
+		if len(rs) == 1 {
+			delete(q.byKey, job)
+		} else {
+			q.byKey[job] = rs[1:]
+		}
+	} else {
+		for i, l := range q.loose {
+			if reflect.DeepEqual(l.job, job) {
+				r = l.r
+				q.loose = append(q.loose[:i], q.loose[i+1:]...)
+				break
+			}
+		}
+		if r == nil {
+			return
+		}
+	}
+
+	q.ack(r)
+	if q.closed {
+		q.release()
+	}
+}
+
+// Close signals that no more jobs will be Put. Once every remaining job has
+// been delivered and acknowledged the segment files are removed.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+	q.release()
+}
+
+// Len returns the number of jobs waiting to be delivered
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ready)
+}
+
+// Sync commits the active segment to stable storage
+func (q *Queue) Sync() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.released {
+		return nil
+	}
+	return q.active().f.Sync()
+}
+
+// Err returns the last error encountered while reading or acknowledging a job
+func (q *Queue) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+func (q *Queue) active() *segment {
+	return q.segs[len(q.segs)-1]
+}
+
+func (q *Queue) ack(r *record) {
+	if _, err := q.write(kindAck, r.id, nil); err != nil {
+		q.err = err
+	}
+	r.seg.live--
+	q.compact()
+}
+
+// write appends a record to the active segment, starting a new segment once
+// the active one has grown past MaxSegmentSize
+func (q *Queue) write(kind byte, id uint64, payload []byte) (*record, error) {
+	if q.active().size >= q.opts.MaxSegmentSize {
+		if err := q.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	s := q.active()
+
+	buf := make([]byte, headerSize+bodySize+len(payload))
+	body := buf[headerSize:]
+	body[0] = kind
+	binary.BigEndian.PutUint64(body[1:], id)
+	copy(body[bodySize:], payload)
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(body)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(body))
+
+	if _, err := s.f.Write(buf); err != nil {
+		return nil, err
+	}
+	if q.opts.Sync {
+		if err := s.f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &record{id: id, seg: s, off: s.size + headerSize + bodySize, n: len(payload)}
+	s.size += int64(len(buf))
+	return r, nil
+}
+
+func (q *Queue) read(r *record) (interface{}, error) {
+	data := make([]byte, r.n)
+	if _, err := r.seg.f.ReadAt(data, r.off); err != nil {
+		return nil, err
+	}
+	if r.env {
+		return q.decodeEnvelope(data)
+	}
+	return q.opts.Codec.Decode(data)
+}
+
+// encodeEnvelope encodes the length of the metadata, the metadata and the job
+func (q *Queue) encodeEnvelope(e *pipeline.Envelope) ([]byte, error) {
+	meta, err := json.Marshal(e.Metadata())
+	if err != nil {
+		return nil, err
+	}
+	job, err := q.opts.Codec.Encode(e.Job)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(meta)+len(job))
+	data = data[:binary.PutUvarint(data, uint64(len(meta)))]
+	data = append(data, meta...)
+	return append(data, job...), nil
+}
+
+func (q *Queue) decodeEnvelope(data []byte) (interface{}, error) {
+	n, k := binary.Uvarint(data)
+	if k <= 0 || uint64(len(data)-k) < n {
+		return nil, ErrCorrupt
+	}
+	var md pipeline.Metadata
+	if err := json.Unmarshal(data[k:k+int(n)], &md); err != nil {
+		return nil, err
+	}
+	job, err := q.opts.Codec.Decode(data[k+int(n):])
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.Rewrap(job, md), nil
+}
+
+func (q *Queue) rotate() error {
+	var seq uint64
+	if len(q.segs) > 0 {
+		seq = q.active().seq + 1
+	}
+
+	f, err := os.OpenFile(q.path(seq), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	q.segs = append(q.segs, &segment{seq: seq, f: f})
+	return nil
+}
+
+// compact removes the oldest segments once all of their jobs are acknowledged.
+// Segments are only ever removed from the front of the log since a segment
+// may hold the acks for jobs in the segments before it.
+func (q *Queue) compact() {
+	for len(q.segs) > 1 && q.segs[0].live == 0 {
+		s := q.segs[0]
+		s.f.Close()
+		if err := os.Remove(s.f.Name()); err != nil {
+			q.err = err
+		}
+		q.segs[0] = nil
+		q.segs = q.segs[1:]
+	}
+}
+
+// release removes the log once the queue is closed and fully acknowledged
+func (q *Queue) release() {
+	if q.released || !q.closed || len(q.ready) > 0 || len(q.byKey) > 0 || len(q.loose) > 0 {
+		return
+	}
+	q.released = true
+	for _, s := range q.segs {
+		s.f.Close()
+		if err := os.Remove(s.f.Name()); err != nil {
+			q.err = err
+		}
+	}
+}
+
+func (q *Queue) closeFiles() {
+	for _, s := range q.segs {
+		s.f.Close()
+	}
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, suffix))
+}
+
+// replay rebuilds the pending jobs from the segments in the directory
+func (q *Queue) replay() error {
+	names, err := filepath.Glob(filepath.Join(q.dir, "*"+suffix))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	jobs := make(map[uint64]*record)
+	var order []*record
+
+	for i, name := range names {
+		var seq uint64
+		if _, err := fmt.Sscanf(filepath.Base(name), "%020d"+suffix, &seq); err != nil {
+			continue
+		}
+
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		s := &segment{seq: seq, f: f}
+		q.segs = append(q.segs, s)
+
+		last := i == len(names)-1
+		err = scan(f, func(kind byte, id uint64, off int64, n int) {
+			switch kind {
+			case kindJob, kindEnvelope:
+				r := &record{id: id, seg: s, off: off, n: n, env: kind == kindEnvelope}
+				jobs[id] = r
+				order = append(order, r)
+				s.live++
+				if id >= q.next {
+					q.next = id + 1
+				}
+			case kindAck:
+				if r, ok := jobs[id]; ok {
+					r.seg.live--
+					delete(jobs, id)
+				}
+			}
+		}, &s.size)
+
+		if err != nil {
+			if !last {
+				return fmt.Errorf("%v: %v", ErrCorrupt, name)
+			}
+			// a torn write at the end of the log; drop the partial record
+			if err := f.Truncate(s.size); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range order {
+		if _, ok := jobs[r.id]; ok {
+			q.ready = append(q.ready, r)
+		}
+	}
+	return nil
+}
+
+// scan calls fn for every intact record in the segment. size is set to the
+// offset following the last intact record.
+func scan(f *os.File, fn func(kind byte, id uint64, off int64, n int), size *int64) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	rd := bufio.NewReader(f)
+	header := make([]byte, headerSize)
+
+	for {
+		if _, err := io.ReadFull(rd, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		n := binary.BigEndian.Uint32(header[0:])
+		if n < bodySize {
+			return ErrCorrupt
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(rd, body); err != nil {
+			return err
+		}
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+			return ErrCorrupt
+		}
+
+		fn(body[0], binary.BigEndian.Uint64(body[1:]), *size+headerSize+bodySize, int(n)-bodySize)
+		*size += headerSize + int64(n)
+	}
+}
+
+func comparable(job interface{}) bool {
+	return job != nil && reflect.TypeOf(job).Comparable()
+}
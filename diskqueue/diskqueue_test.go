@@ -0,0 +1,436 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package diskqueue_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/diskqueue"
+)
+
+var _ pipeline.Queue = &diskqueue.Queue{}
+var _ pipeline.Acknowledger = &diskqueue.Queue{}
+
+type Job struct {
+	ID   int
+	Path string
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func jsonOptions() diskqueue.Options {
+	opts := diskqueue.DefaultOptions()
+	opts.Codec = diskqueue.JSONCodec{New: func() interface{} { return &Job{} }}
+	return opts
+}
+
+func TestPutGetAck(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := q.Put(&Job{ID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Close()
+
+	if q.Put(&Job{ID: 4}) != diskqueue.ErrClosed {
+		t.Errorf("expected ErrClosed after Close")
+	}
+
+	for i := 1; i <= 3; i++ {
+		job, ok := q.Get()
+		if !ok {
+			t.Fatalf("expected job %v", i)
+		}
+		if job.(*Job).ID != i {
+			t.Errorf("expected job %v, got %v", i, job.(*Job).ID)
+		}
+		q.Ack(job)
+	}
+
+	if _, ok := q.Get(); ok {
+		t.Errorf("expected the queue to be drained")
+	}
+
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(segs) != 0 {
+		t.Errorf("expected the segments to be removed, found %v", len(segs))
+	}
+}
+
+func TestRedeliverUnacknowledged(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		q.Put(&Job{ID: i})
+	}
+
+	first, _ := q.Get()
+	q.Ack(first)
+	q.Get() // delivered but never acknowledged
+
+	// reopen without closing to simulate a crash
+	q, err = diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 2 {
+		t.Fatalf("expected 2 jobs after reopening, got %v", q.Len())
+	}
+
+	for _, id := range []int{2, 3} {
+		job, _ := q.Get()
+		if job.(*Job).ID != id {
+			t.Errorf("expected job %v, got %v", id, job.(*Job).ID)
+		}
+	}
+}
+
+func TestSegmentCompaction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := jsonOptions()
+	opts.MaxSegmentSize = 64
+	q, err := diskqueue.Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		q.Put(&Job{ID: i, Path: "/some/path"})
+	}
+	before, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+
+	for i := 0; i < 15; i++ {
+		job, _ := q.Get()
+		q.Ack(job)
+	}
+	after, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+
+	if len(after) >= len(before) {
+		t.Errorf("expected acknowledged segments to be removed; before=%v, after=%v", len(before), len(after))
+	}
+
+	q, err = diskqueue.Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 5 {
+		t.Errorf("expected 5 jobs after reopening, got %v", q.Len())
+	}
+}
+
+func TestTornWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Put(&Job{ID: 1})
+	q.Put(&Job{ID: 2})
+
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	f, err := os.OpenFile(segs[len(segs)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2})
+	f.Close()
+
+	q, err = diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 2 {
+		t.Errorf("expected 2 jobs after dropping the torn record, got %v", q.Len())
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := diskqueue.Open(dir, diskqueue.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := pipeline.New()
+	p.SetGenerator(&countingGenerator{})
+	first, second := &countingStage{}, &countingStage{}
+	p.AddStage(first, second)
+
+	if err := p.SetQueue(1, q); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.count != 10 || second.count != 10 {
+		t.Errorf("expected 10 jobs through each stage; first=%v, second=%v", first.count, second.count)
+	}
+
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(segs) != 0 {
+		t.Errorf("expected the segments to be removed, found %v", len(segs))
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enqueued := time.Unix(100, 0).UTC()
+	e := pipeline.Rewrap(&Job{ID: 1, Path: "a"}, pipeline.Metadata{
+		Seq:        7,
+		Enqueued:   enqueued,
+		Timings:    []pipeline.StageTiming{{Stage: "fetch", Start: enqueued, End: enqueued.Add(time.Second)}},
+		Attributes: map[string]interface{}{"trace": "abc"},
+	})
+	if err := q.Put(e); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	// the envelope is redelivered, whole, after a restart
+	q, err = diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, ok := q.Get()
+	if !ok {
+		t.Fatal("expected the envelope to be redelivered")
+	}
+	got, ok := job.(*pipeline.Envelope)
+	if !ok {
+		t.Fatalf("expected an envelope, got %T", job)
+	}
+	if j := got.Job.(*Job); j.ID != 1 || j.Path != "a" {
+		t.Errorf("unexpected job %+v", j)
+	}
+	if got.Seq != 7 || !got.Enqueued.Equal(enqueued) {
+		t.Errorf("unexpected envelope seq=%v, enqueued=%v", got.Seq, got.Enqueued)
+	}
+	if v, _ := got.Get("trace"); v != "abc" {
+		t.Errorf("expected the trace attribute, got %v", v)
+	}
+	if timings := got.Timings(); len(timings) != 1 || timings[0].Stage != "fetch" || timings[0].End.Sub(timings[0].Start) != time.Second {
+		t.Errorf("unexpected timings %+v", timings)
+	}
+	q.Ack(job)
+	q.Close()
+}
+
+func TestPipelineMetadata(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := pipeline.DefaultConfig()
+	cfg.Metadata = true
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&jobGenerator{})
+	tagging, checking := &envelopeStage{tag: true}, &envelopeStage{}
+	p.AddStage(tagging, checking)
+
+	if err := p.SetQueue(1, q); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := p.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 10 || checking.count != 10 {
+		t.Fatalf("expected 10 jobs, got %v", len(jobs))
+	}
+	for i, job := range jobs {
+		if job.(*Job).ID == 0 {
+			t.Errorf("job %v: expected the job to survive the queue", i)
+		}
+	}
+	if checking.errors != 0 {
+		t.Errorf("expected the metadata to survive the queue, %v jobs lost it", checking.errors)
+	}
+}
+
+type jobGenerator struct {
+	n int
+}
+
+func (g *jobGenerator) Name() string {
+	return "jobGenerator"
+}
+
+func (g *jobGenerator) Next() interface{} {
+	g.n++
+	if g.n <= 10 {
+		return &Job{ID: g.n}
+	}
+	return nil
+}
+
+func (g *jobGenerator) Abort() {
+}
+
+// envelopeStage tags each envelope with its job id or checks the tag
+type envelopeStage struct {
+	tag           bool
+	count, errors int
+}
+
+func (s *envelopeStage) Name() string {
+	return "envelopeStage"
+}
+
+func (s *envelopeStage) Concurrency() int {
+	return 1
+}
+
+func (s *envelopeStage) Process(interface{}) {
+}
+
+func (s *envelopeStage) ProcessEnvelope(e *pipeline.Envelope) error {
+	id := e.Job.(*Job).ID
+	if s.tag {
+		e.Set("id", id)
+		return nil
+	}
+
+	s.count++
+	// the attribute comes back from JSON as a float64
+	if v, _ := e.Get("id"); v != float64(id) || e.Seq != uint64(id) || len(e.Timings()) != 1 {
+		s.errors++
+	}
+	return nil
+}
+
+func TestBoundedQueueRecoversSpill(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		q.Put(&Job{ID: i})
+	}
+
+	// the jobs left in the spill queue by an earlier run come out first
+	q, err = diskqueue.Open(dir, jsonOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bq := pipeline.NewBoundedQueue(1, pipeline.Spill, q)
+	bq.Put(&Job{ID: 4})
+	bq.Put(&Job{ID: 5})
+	if bq.Len() != 5 {
+		t.Errorf("expected 5 jobs, got %v", bq.Len())
+	}
+	bq.Close()
+
+	for i := 1; i <= 5; i++ {
+		job, ok := bq.Get()
+		if !ok {
+			t.Fatalf("expected job %v", i)
+		}
+		if job.(*Job).ID != i {
+			t.Errorf("expected job %v, got %v", i, job.(*Job).ID)
+		}
+	}
+	if _, ok := bq.Get(); ok || q.Len() != 0 {
+		t.Errorf("expected the queues to be drained, %v left in the spill queue", q.Len())
+	}
+}
+
+type countingGenerator struct {
+	n int
+}
+
+func (g *countingGenerator) Name() string {
+	return "countingGenerator"
+}
+
+func (g *countingGenerator) Next() interface{} {
+	g.n++
+	if g.n <= 10 {
+		return g.n
+	}
+	return nil
+}
+
+func (g *countingGenerator) Abort() {
+}
+
+type countingStage struct {
+	count int
+}
+
+func (s *countingStage) Name() string {
+	return "countingStage"
+}
+
+func (s *countingStage) Concurrency() int {
+	return 1
+}
+
+func (s *countingStage) Process(interface{}) {
+	s.count++
+}
@@ -59,6 +59,222 @@ developer.
 A job (a user defined structure) is retrieved from the Generator Next() call (as an
 interface{}) which is then passed to each stage via the Process() call.
 
+Generators
+
+The generators package provides ready made generators for a slice, a channel,
+the lines of an io.Reader, a directory walk filtered by glob or regexp, a ticker
+and CSV or JSON lines input. Each of them stops promptly on Abort.
+
+	p.SetGenerator(generators.Walk("/data", generators.WalkOptions{Glob: "*.log"}))
+
+Seq, Seq2 and Func adapt a Go iterator, or any function that yields jobs, to a
+Generator.
+
+	p.SetGenerator(generators.Seq(slices.Values(paths)))
+
+Output
+
+Run discards the jobs that leave the final stage. To use a pipeline as a
+function inside a larger program, set Config.Output to receive each completed
+job, call Collect to run the pipeline and return them, or range over Results;
+breaking out of that loop aborts the pipeline.
+
+	for job, err := range p.Results() {
+		...
+	}
+
+Start launches the pipeline without blocking. The Handle it returns has Wait,
+Done, Abort, Stats and Snapshot so the pipeline can be supervised from a select
+loop.
+
+	h, err := p.Start()
+	...
+	select {
+	case <-h.Done():
+	case <-ctx.Done():
+		h.Abort()
+		h.Wait()
+	}
+
+Streaming
+
+A generator may run forever. Config.Stream enables the streaming mode: the
+pipeline samples its counters to report rolling throughput through Health, logs
+a heartbeat, detects an idle generator and fails Live when a stage with work to
+do stops making progress.
+
+	cfg.Stream = pipeline.StreamConfig{
+		Heartbeat: time.Minute,
+		Idle:      5 * time.Minute,
+		Stall:     time.Minute,
+	}
+
+Emitting jobs
+
+A stage that implements Emitter decides which jobs go downstream instead of
+passing each job on, and one that implements Flusher is given a last chance to
+emit the jobs it holds once its input is exhausted. The window package builds
+tumbling and sliding windows of event time on them, emitting one aggregate job
+per window and dropping jobs that arrive later than the allowed lateness.
+
+	p.AddStage(window.Tumbling(time.Minute, window.Options{Time: eventTime}))
+
+The dedup package drops jobs whose key has been seen before, remembering the
+keys in an in-memory LRU with a time to live, a Bloom filter or a file that
+carries them over to the next run.
+
+	p.AddStage(dedup.New(dedup.NewLRU(100000, time.Hour), dedup.Options{}))
+
+Queues
+
+Jobs move between stages through a Queue. By default each queue is a ChanQueue;
+a crash loses any job still buffered in it. Config.NewQueue selects a different
+implementation for every boundary, and SetQueue replaces a single queue once the
+stages have been added, without any change to the stages themselves.
+
+	type Queue interface {
+		Put(job interface{}) error
+		Get() (interface{}, bool)
+		Close()
+		Len() int
+	}
+
+RingQueue and InstrumentedQueue are provided here, and the diskqueue package
+provides a durable queue backed by an append-only log on local disk. A queue
+that implements Acknowledger is told when the consuming stage has finished with
+a job so it can be released.
+
+	q, err := diskqueue.Open("/var/lib/myapp/parse", diskqueue.DefaultOptions())
+	p.SetQueue(1, q) // the queue feeding parse.Stage
+
+A job that a queue refuses, with an error from Put, is lost; it is counted as
+Rejected in the Stats and as an error of the stage that sent it. The diskqueue
+GobCodec can only encode job types registered with gob.Register.
+
+A PriorityQueue dispatches jobs that implement Prioritized ahead of lower priority
+jobs, aging waiting jobs so bulk work is never starved.
+
+	p.SetQueue(0, pipeline.NewPriorityQueue(100, time.Second))
+
+When a downstream stage is slow a full queue blocks the stages upstream and,
+eventually, the generator. A BoundedQueue applies an Overflow policy instead:
+Block, DropNewest, DropOldest or Spill to a secondary queue such as a diskqueue.
+Dropped and spilled jobs are counted and reported by Stats.
+
+	p.SetQueue(2, pipeline.NewBoundedQueue(1000, pipeline.DropOldest, nil))
+
+Stage options
+
+AddStageWithOptions overrides the concurrency of a stage and adds a per-job
+timeout. A stage that implements Retryable reports failures from TryProcess and
+is retried up to the configured number of times.
+
+	p.AddStageWithOptions(fetch.Stage, pipeline.StageOptions{
+		Concurrency: 32,
+		Retries:     3,
+		RetryDelay:  time.Second,
+		Timeout:     time.Minute,
+	})
+
+A concurrent stage hands each job to whichever worker is free, so two jobs for
+the same key may be processed at once. A Partitioned stage instead hashes the
+key of each job, from StageOptions.Key or a job that implements Keyed, to a
+fixed worker: the jobs for a key are processed one at a time and in order while
+the stage still runs all of its workers.
+
+	p.AddStageWithOptions(store.Stage, pipeline.StageOptions{Partitioned: true})
+
+StageOptions.When selects the jobs a stage applies to. The others bypass the
+stage without taking one of its workers, go straight on to the next stage and
+are counted as Skipped in the Snapshot.
+
+	p.AddStageWithOptions(hash.Stage, pipeline.StageOptions{
+		When: func(job interface{}) bool { return job.(*File).Size < 1<<30 },
+	})
+
+A Middleware wraps a stage to add cross-cutting behavior around each job.
+Config.Middleware applies to every stage and StageOptions.Middleware to one.
+Timing, Logging and Recover are provided, and Intercept writes others while
+keeping the optional interfaces of the stage it wraps.
+
+	cfg.Middleware = []pipeline.Middleware{pipeline.Recover(), pipeline.Logging(logger)}
+
+The cache package wraps an expensive stage so that a job whose key has a
+stored result, in memory or in a file on local disk, is given that result
+instead of being processed again. Results expire after a time to live and the
+wrapper counts its hits and misses.
+
+	p.AddStage(cache.Wrap(hash.Stage, cache.NewMemory(), opts))
+
+Compose packages a sequence of stages as a single Stage that can be added to
+any pipeline. Its stages are added in its place, each keeping its own
+concurrency, queue and stats under the name "composite/stage". A When set on
+the Composite lets the other jobs bypass all of its stages.
+
+	ingest := pipeline.Compose("ingest", fetch.Stage, parse.Stage, validate.Stage)
+	p.AddStage(ingest, store.Stage)
+
+With Config.Metadata set each job travels in an Envelope that carries a
+sequence number, the time it was enqueued, the timing of every stage it has
+passed through and attributes set by the stages. A stage that implements
+EnvelopeProcessor, or a middleware through Invocation.Envelope, is given the
+envelope; other stages, the Output and the Results still see the bare job.
+A custom Queue sees the *Envelope, and Unwrap returns the job inside it; the
+diskqueue stores the job, with its codec, alongside its Metadata.
+
+	func (s *stage) ProcessEnvelope(e *pipeline.Envelope) error {
+		e.Set("trace", traceID(e.Job))
+		...
+	}
+
+The registry package builds a pipeline from a JSON (or YAML) definition that
+names registered stage and generator constructors, so a deployment can be tuned
+without recompiling. The cli package wraps a registry in a command line that
+applies flag and environment overrides, aborts gracefully on SIGINT/SIGTERM,
+prints a summary and supports a dry-run; see example/runner.
+
+Testing
+
+The pipelinetest package provides a SliceGenerator, a Recorder sink stage,
+RunStage to run a single stage over a list of jobs, assertions on the output
+and CheckLeaks to detect goroutines left running after Run returns.
+
+Time and simulation
+
+The pipeline keeps time through Config.Clock. A stage or generator that
+implements Clocked is given the clock, and one that implements Seeded is given a
+random source derived from Config.Seed, so that timeouts, retry delays and the
+stages themselves can run against a VirtualClock that a test steps with Advance.
+
+Simulate runs the pipeline on a single goroutine in virtual time, ordering the
+work of every worker by the virtual time it can start and breaking ties with a
+seeded random source. The same seed reproduces the same interleaving.
+
+	p := pipeline.NewWithConfig(pipeline.Config{Clock: pipeline.NewVirtualClock(start)})
+	...
+	p.Simulate(42)
+
+Introspection
+
+Snapshot may be called from another goroutine while Run is blocking. It reports,
+for each stage, the configured and active workers, the length and capacity of
+the queue feeding it, the number of jobs processed and in flight, and how long
+the oldest in-flight job has been running. Resize changes the number of workers
+for a stage while the pipeline is running.
+
+Pause stops the pipeline from pulling jobs from the generator, without aborting,
+until Resume is called; the workers and their state are kept. PauseStage and
+ResumeStage do the same for a single stage.
+
+DOT and Mermaid render the generator, stages, concurrency and queue depths, along
+with the live counts while the pipeline is running, for documentation and
+diagrams.
+
+The admin package serves a Snapshot as JSON along with a minimal HTML dashboard
+and endpoints to abort, pause and resume the pipeline and resize stages.
+
+	http.Handle("/pipeline/", http.StripPrefix("/pipeline", admin.NewHandler(p)))
+
 Types
 
 The Generator interface requires a Next() function that is called to retreive the
@@ -0,0 +1,178 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Envelope carries the metadata the pipeline keeps for a job when
+// Config.Metadata is set: the order in which the generator produced it, the
+// time it entered the pipeline, the time spent in each stage and attributes
+// set by the stages. The envelope, not the job, moves through the queues;
+// stages, Output and Results still see the job itself.
+type Envelope struct {
+	_        struct{}
+	Job      interface{}
+	Seq      uint64
+	Enqueued time.Time
+	mu       sync.Mutex
+	timings  []StageTiming
+	attrs    map[string]interface{}
+}
+
+// StageTiming records the time a stage spent on a job
+type StageTiming struct {
+	_     struct{}
+	Stage string
+	Start time.Time
+	End   time.Time
+}
+
+// Metadata is the metadata of an Envelope in a form that can be encoded, for a
+// Queue that stores its jobs outside of memory. Such a queue encodes the job
+// and its Metadata separately and, on Get, joins them again with Rewrap.
+type Metadata struct {
+	_          struct{}
+	Seq        uint64
+	Enqueued   time.Time
+	Timings    []StageTiming
+	Attributes map[string]interface{}
+}
+
+// EnvelopeProcessor may be implemented by a Stage that reads or sets the
+// metadata of its jobs. When Config.Metadata is set the pipeline calls
+// ProcessEnvelope in place of Process, retrying it like TryProcess.
+type EnvelopeProcessor interface {
+	ProcessEnvelope(e *Envelope) error
+}
+
+// Get returns the value of an attribute
+func (e *Envelope) Get(key string) (interface{}, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.attrs[key]
+	return v, ok
+}
+
+// Set sets the value of an attribute
+func (e *Envelope) Set(key string, value interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.attrs == nil {
+		e.attrs = make(map[string]interface{})
+	}
+	e.attrs[key] = value
+}
+
+// Attributes returns a copy of the attributes
+func (e *Envelope) Attributes() map[string]interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	attrs := make(map[string]interface{}, len(e.attrs))
+	for k, v := range e.attrs {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// Timings returns the time spent in each stage the job has left, in order
+func (e *Envelope) Timings() []StageTiming {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]StageTiming(nil), e.timings...)
+}
+
+// Metadata returns a copy of the metadata of the envelope
+func (e *Envelope) Metadata() Metadata {
+	return Metadata{
+		Seq:        e.Seq,
+		Enqueued:   e.Enqueued,
+		Timings:    e.Timings(),
+		Attributes: e.Attributes(),
+	}
+}
+
+// Rewrap returns an Envelope holding the job with the metadata
+func Rewrap(job interface{}, md Metadata) *Envelope {
+	e := &Envelope{
+		Job:      job,
+		Seq:      md.Seq,
+		Enqueued: md.Enqueued,
+		timings:  append([]StageTiming(nil), md.Timings...),
+	}
+	for k, v := range md.Attributes {
+		e.Set(k, v)
+	}
+	return e
+}
+
+func (e *Envelope) record(t StageTiming) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.timings = append(e.timings, t)
+}
+
+// derive returns an envelope for a job emitted while processing e; it keeps
+// the sequence number, enqueue time, timings and attributes of e
+func (e *Envelope) derive(job interface{}) *Envelope {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	d := &Envelope{
+		Job:      job,
+		Seq:      e.Seq,
+		Enqueued: e.Enqueued,
+		timings:  append([]StageTiming(nil), e.timings...),
+	}
+	if e.attrs != nil {
+		d.attrs = make(map[string]interface{}, len(e.attrs))
+		for k, v := range e.attrs {
+			d.attrs[k] = v
+		}
+	}
+	return d
+}
+
+// Unwrap returns the job held by an Envelope, or job itself. A Queue that
+// inspects its jobs, such as one checking for Prioritized, should unwrap them
+// first.
+func Unwrap(job interface{}) interface{} {
+	if e, ok := job.(*Envelope); ok {
+		return e.Job
+	}
+	return job
+}
+
+// envelope wraps a job entering the pipeline when Config.Metadata is set
+func (p *Pipeline) envelope(job interface{}) interface{} {
+	if !p.config.Metadata {
+		return job
+	}
+	return &Envelope{
+		Job:      job,
+		Seq:      atomic.AddUint64(&p.seq, 1),
+		Enqueued: p.clock().Now(),
+	}
+}
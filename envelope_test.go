@@ -0,0 +1,185 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+func TestMetadata(t *testing.T) {
+	var out []interface{}
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	cfg.Metadata = true
+	cfg.Output = func(job interface{}) {
+		out = append(out, job)
+	}
+
+	recorder := &EnvelopeStage{}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&SleepingStage{Sleep: time.Second})
+	p.AddStage(recorder)
+
+	if err := p.Simulate(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != 10 || out[0] != 1 {
+		t.Errorf("expected the jobs themselves as output, got %v", out)
+	}
+	if len(recorder.Envelopes) != 10 {
+		t.Fatalf("expected 10 envelopes, got %v", len(recorder.Envelopes))
+	}
+	for i, e := range recorder.Envelopes {
+		if e.Seq != uint64(e.Job.(int)) {
+			t.Errorf("expected the sequence number to follow the generator, got %v for job %v", e.Seq, e.Job)
+		}
+
+		// one timing for each stage the job has passed through
+		timings := e.Timings()
+		if len(timings) != 2 || timings[0].Stage != "SleepingStage" || timings[0].End.Sub(timings[0].Start) != time.Second || timings[1].Stage != "EnvelopeStage" {
+			t.Errorf("envelope %v: unexpected timings %+v", i, timings)
+		}
+		if timings[0].Start.Before(e.Enqueued) {
+			t.Errorf("envelope %v: started before it was enqueued", i)
+		}
+		if v, ok := e.Get("seen"); !ok || v != e.Job {
+			t.Errorf("envelope %v: expected the attribute to be set", i)
+		}
+	}
+}
+
+func TestMetadataDisabled(t *testing.T) {
+	recorder := &EnvelopeStage{}
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(recorder)
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if len(recorder.Envelopes) != 0 || recorder.Processed != 10 {
+		t.Errorf("expected Process to be called without metadata")
+	}
+}
+
+func TestMetadataMiddleware(t *testing.T) {
+	var mu sync.Mutex
+	seqs := make(map[uint64]bool)
+
+	cfg := pipeline.DefaultConfig()
+	cfg.Metadata = true
+	cfg.Middleware = []pipeline.Middleware{pipeline.Intercept(func(inv *pipeline.Invocation) error {
+		mu.Lock()
+		seqs[inv.Envelope.Seq] = inv.Envelope.Job == inv.Job
+		mu.Unlock()
+		return inv.Proceed()
+	})}
+
+	counting := &CountingStage{}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(counting)
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if len(seqs) != 10 || counting.ProcessCount != 10 {
+		t.Errorf("expected the middleware to see 10 envelopes, got %v", seqs)
+	}
+	for seq, same := range seqs {
+		if !same {
+			t.Errorf("envelope %v does not hold the job", seq)
+		}
+	}
+}
+
+func TestMetadataEmitter(t *testing.T) {
+	recorder := &EnvelopeStage{}
+	cfg := pipeline.DefaultConfig()
+	cfg.Metadata = true
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&OneJobGenerator{})
+	p.AddStage(&EmittingStage{})
+	p.AddStage(recorder)
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	// the emitted jobs keep the envelope of their source; a flushed job gets a new one
+	var seqs []uint64
+	for _, e := range recorder.Envelopes {
+		seqs = append(seqs, e.Seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 1 || seqs[1] != 1 || seqs[2] != 2 {
+		t.Errorf("unexpected sequence numbers %v", seqs)
+	}
+}
+
+func TestPriorityQueueEnvelope(t *testing.T) {
+	q := pipeline.NewPriorityQueue(0, 0)
+	q.Put(&pipeline.Envelope{Job: &PriorityJob{ID: 1, priority: 0}})
+	q.Put(&pipeline.Envelope{Job: &PriorityJob{ID: 2, priority: 5}})
+	q.Close()
+
+	job, _ := q.Get()
+	if pipeline.Unwrap(job).(*PriorityJob).ID != 2 {
+		t.Errorf("expected the priority of the job to be used")
+	}
+}
+
+/* test stage */
+type EnvelopeStage struct {
+	mu        sync.Mutex
+	Envelopes []*pipeline.Envelope
+	Processed int
+}
+
+func (s *EnvelopeStage) Name() string {
+	return "EnvelopeStage"
+}
+
+func (s *EnvelopeStage) Concurrency() int {
+	return 1
+}
+
+func (s *EnvelopeStage) Process(interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Processed++
+}
+
+func (s *EnvelopeStage) ProcessEnvelope(e *pipeline.Envelope) error {
+	e.Set("seen", e.Job)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Envelopes = append(s.Envelopes, e)
+	return nil
+}
@@ -26,13 +26,16 @@ import (
 	"math/rand"
 	"time"
 
+	"github.com/jboelter/pipeline"
 	"github.com/jboelter/pipeline/example/job"
 
 	"log"
 )
 
 type Delay struct {
-	log *log.Logger
+	log   *log.Logger
+	clock pipeline.Clock
+	rand  *rand.Rand
 }
 
 var Stage = &Delay{}
@@ -41,6 +44,16 @@ func (s *Delay) SetLogger(l *log.Logger) {
 	s.log = l
 }
 
+// SetClock is called by the pipeline; a VirtualClock makes the delay instant
+func (s *Delay) SetClock(c pipeline.Clock) {
+	s.clock = c
+}
+
+// SetRand is called by the pipeline when it is seeded
+func (s *Delay) SetRand(r *rand.Rand) {
+	s.rand = r
+}
+
 func (s *Delay) Name() string {
 	return "Delay"
 }
@@ -54,8 +67,18 @@ func (s *Delay) Process(i interface{}) {
 	j := i.(*job.Job)
 
 	// a dummy step that delays...
-	d := rand.Intn(100)
-	time.Sleep(time.Millisecond * time.Duration(d))
+	var d int
+	if s.rand != nil {
+		d = s.rand.Intn(100)
+	} else {
+		d = rand.Intn(100)
+	}
+
+	if s.clock != nil {
+		s.clock.Sleep(time.Millisecond * time.Duration(d))
+	} else {
+		time.Sleep(time.Millisecond * time.Duration(d))
+	}
 
 	s.log.Printf("source=stage, name=delay, id=%v, delay=%v", j.ID, time.Millisecond*time.Duration(d))
 }
@@ -58,6 +58,8 @@ func main() {
 	cfg.Buffered = flagBuffer
 	cfg.Depth = 0
 	cfg.Verbose = flagVerbose
+	// count a panic in any stage as an error rather than crash
+	cfg.Middleware = []pipeline.Middleware{pipeline.Recover()}
 	p := pipeline.NewWithConfig(cfg)
 
 	generator.Generator.Initialize(os.Getenv("GOPATH"), `.*\.go$`, logger)
@@ -0,0 +1,78 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/cli"
+	"github.com/jboelter/pipeline/example/delay"
+	"github.com/jboelter/pipeline/example/echo"
+	"github.com/jboelter/pipeline/example/generator"
+	"github.com/jboelter/pipeline/example/hash"
+	"github.com/jboelter/pipeline/example/terminus"
+	"github.com/jboelter/pipeline/registry"
+)
+
+// run with: go run main.go -config pipeline.json
+func main() {
+
+	logger := log.New(os.Stdout, "", 0)
+
+	registry.RegisterGenerator("localfs", func(params registry.Params) (pipeline.Generator, error) {
+		path, err := params.String("path", os.Getenv("GOPATH"))
+		if err != nil {
+			return nil, err
+		}
+		match, err := params.String("match", `.*\.go$`)
+		if err != nil {
+			return nil, err
+		}
+		generator.Generator.Initialize(path, match, logger)
+		return generator.Generator, nil
+	})
+
+	registry.RegisterStage("hash", func(registry.Params) (pipeline.Stage, error) {
+		hash.Stage.SetLogger(logger)
+		return hash.Stage, nil
+	})
+
+	registry.RegisterStage("delay", func(registry.Params) (pipeline.Stage, error) {
+		delay.Stage.SetLogger(logger)
+		return delay.Stage, nil
+	})
+
+	registry.RegisterStage("echo", func(registry.Params) (pipeline.Stage, error) {
+		echo.Stage.SetLogger(logger)
+		return echo.Stage, nil
+	})
+
+	registry.RegisterStage("terminus", func(registry.Params) (pipeline.Stage, error) {
+		terminus.Stage.SetLogger(logger)
+		return terminus.Stage, nil
+	})
+
+	cli.Main(registry.Default)
+}
@@ -0,0 +1,165 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package generators provides ready made pipeline.Generators for common
+// sources: slices, channels, lines of text, directory walks, tickers and CSV
+// or JSON lines files.
+//
+// Each generator produces its jobs on a goroutine that is started by the first
+// call to Next, so creating one does no work. Abort stops the generator
+// promptly, even while the source is blocked; Next returns nil from then on.
+// An error from the source also ends the generator and is reported by Err.
+package generators
+
+import (
+	"sync"
+
+	"github.com/jboelter/pipeline"
+)
+
+// Generator is a pipeline.Generator fed by a producer goroutine
+type Generator struct {
+	_       struct{}
+	name    string
+	produce func(g *Generator) error
+	jobs    chan interface{}
+	quit    chan struct{}
+	start   sync.Once
+	abort   sync.Once
+	mu      sync.Mutex
+	clock   pipeline.Clock
+	err     error
+}
+
+func newGenerator(name string, produce func(g *Generator) error) *Generator {
+	return &Generator{
+		name:    name,
+		produce: produce,
+		jobs:    make(chan interface{}),
+		quit:    make(chan struct{}),
+		clock:   pipeline.SystemClock{},
+	}
+}
+
+// Name implements pipeline.Generator
+func (g *Generator) Name() string {
+	return g.name
+}
+
+// Next implements pipeline.Generator
+func (g *Generator) Next() interface{} {
+	g.start.Do(func() {
+		go func() {
+			defer close(g.jobs)
+			if err := g.produce(g); err != nil {
+				g.mu.Lock()
+				g.err = err
+				g.mu.Unlock()
+			}
+		}()
+	})
+
+	select {
+	case job, ok := <-g.jobs:
+		if !ok {
+			return nil
+		}
+		return job
+	case <-g.quit:
+		return nil
+	}
+}
+
+// Abort implements pipeline.Generator
+func (g *Generator) Abort() {
+	g.abort.Do(func() {
+		close(g.quit)
+	})
+}
+
+// SetClock implements pipeline.Clocked
+func (g *Generator) SetClock(c pipeline.Clock) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.clock = c
+}
+
+// Err returns the error that ended the generator, if any
+func (g *Generator) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// emit hands a job to Next; it returns false once the generator is aborted
+func (g *Generator) emit(job interface{}) bool {
+	select {
+	case g.jobs <- job:
+		return true
+	case <-g.quit:
+		return false
+	}
+}
+
+func (g *Generator) aborted() bool {
+	select {
+	case <-g.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (g *Generator) getClock() pipeline.Clock {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.clock
+}
+
+// Slice generates the jobs in order
+func Slice(jobs ...interface{}) *Generator {
+	return newGenerator("Slice", func(g *Generator) error {
+		for _, job := range jobs {
+			if !g.emit(job) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Chan generates the jobs received from c until it is closed. Aborting does
+// not close or drain c.
+func Chan(c <-chan interface{}) *Generator {
+	return newGenerator("Chan", func(g *Generator) error {
+		for {
+			select {
+			case job, ok := <-c:
+				if !ok || !g.emit(job) {
+					return nil
+				}
+			case <-g.quit:
+				return nil
+			}
+		}
+	})
+}
@@ -0,0 +1,298 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package generators_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/generators"
+	"github.com/jboelter/pipeline/pipelinetest"
+)
+
+func drain(g pipeline.Generator) []interface{} {
+	var jobs []interface{}
+	for job := g.Next(); job != nil; job = g.Next() {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// abortsPromptly checks that Next unblocks once Abort is called
+func abortsPromptly(t *testing.T, g pipeline.Generator) {
+	t.Helper()
+
+	done := make(chan interface{})
+	go func() {
+		done <- g.Next()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	g.Abort()
+
+	select {
+	case job := <-done:
+		if job != nil {
+			t.Errorf(`Next should return nil after Abort, got %v`, job)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf(`Next did not return after Abort`)
+	}
+}
+
+func TestSlice(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	got := drain(generators.Slice(1, 2, 3))
+	pipelinetest.AssertOrder(t, got, []interface{}{1, 2, 3})
+}
+
+func TestSliceAbort(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	g := generators.Slice(1, 2, 3)
+	if job := g.Next(); job != 1 {
+		t.Errorf(`expected 1, got %v`, job)
+	}
+	g.Abort()
+	if job := g.Next(); job != nil {
+		t.Errorf(`expected nil after Abort, got %v`, job)
+	}
+}
+
+func TestSliceInPipeline(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	rec := &pipelinetest.Recorder{}
+	p := pipeline.NewWithConfig(pipeline.Config{Depth: 1})
+	p.SetGenerator(generators.Slice("a", "b", "c"))
+	p.AddStage(rec)
+	if err := p.Run(); err != nil {
+		t.Fatalf(`error should be nil, got %v`, err)
+	}
+	pipelinetest.AssertOrder(t, rec.Jobs(), []interface{}{"a", "b", "c"})
+}
+
+func TestChan(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	c := make(chan interface{}, 3)
+	c <- "x"
+	c <- "y"
+	close(c)
+
+	got := drain(generators.Chan(c))
+	pipelinetest.AssertOrder(t, got, []interface{}{"x", "y"})
+}
+
+func TestChanAbort(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	abortsPromptly(t, generators.Chan(make(chan interface{})))
+}
+
+func TestLines(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	g := generators.Lines(strings.NewReader("one\ntwo\r\n\nthree"))
+	got := drain(g)
+	pipelinetest.AssertOrder(t, got, []interface{}{"one", "two", "", "three"})
+	if g.Err() != nil {
+		t.Errorf(`error should be nil, got %v`, g.Err())
+	}
+}
+
+func TestLinesAbort(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	abortsPromptly(t, generators.Lines(r))
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestLinesErr(t *testing.T) {
+	g := generators.Lines(errReader{})
+	if got := drain(g); len(got) != 0 {
+		t.Errorf(`expected no jobs, got %v`, got)
+	}
+	if g.Err() != io.ErrUnexpectedEOF {
+		t.Errorf(`expected %v, got %v`, io.ErrUnexpectedEOF, g.Err())
+	}
+}
+
+func TestCSV(t *testing.T) {
+	got := drain(generators.CSV(strings.NewReader("a,b\n1,2\n"), false))
+	want := []interface{}{[]string{"a", "b"}, []string{"1", "2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`expected %v, got %v`, want, got)
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	got := drain(generators.CSV(strings.NewReader("name,size\nx,1\ny,2\n"), true))
+	want := []interface{}{
+		map[string]string{"name": "x", "size": "1"},
+		map[string]string{"name": "y", "size": "2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`expected %v, got %v`, want, got)
+	}
+}
+
+type record struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+}
+
+func TestJSONL(t *testing.T) {
+	in := "{\"path\":\"a\",\"size\":1}\n\n{\"path\":\"b\",\"size\":2}\n"
+
+	got := drain(generators.JSONL(strings.NewReader(in), func() interface{} { return &record{} }))
+	want := []interface{}{&record{Path: "a", Size: 1}, &record{Path: "b", Size: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(`expected %v, got %v`, want, got)
+	}
+
+	got = drain(generators.JSONL(strings.NewReader(in), nil))
+	if len(got) != 2 || got[1].(map[string]interface{})["path"] != "b" {
+		t.Errorf(`unexpected jobs %v`, got)
+	}
+}
+
+func TestJSONLErr(t *testing.T) {
+	g := generators.JSONL(strings.NewReader("{\"path\":\"a\"}\nnot json\n{}\n"), nil)
+	if got := drain(g); len(got) != 1 {
+		t.Errorf(`expected 1 job, got %v`, got)
+	}
+	if g.Err() == nil {
+		t.Errorf(`error should not be nil`)
+	}
+}
+
+func tree(t *testing.T) string {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.go", "sub/c.txt", "sub/d.md"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestWalk(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	dir := tree(t)
+	rel := func(jobs []interface{}) []interface{} {
+		for i, job := range jobs {
+			r, _ := filepath.Rel(dir, job.(string))
+			jobs[i] = filepath.ToSlash(r)
+		}
+		return jobs
+	}
+
+	got := rel(drain(generators.Walk(dir, generators.WalkOptions{})))
+	pipelinetest.AssertOrder(t, got, []interface{}{"a.txt", "b.go", "sub/c.txt", "sub/d.md"})
+
+	got = rel(drain(generators.Walk(dir, generators.WalkOptions{Glob: "*.txt"})))
+	pipelinetest.AssertOrder(t, got, []interface{}{"a.txt", "sub/c.txt"})
+
+	got = rel(drain(generators.Walk(dir, generators.WalkOptions{Match: regexp.MustCompile(`^[bd]\.`)})))
+	pipelinetest.AssertOrder(t, got, []interface{}{"b.go", "sub/d.md"})
+
+	got = rel(drain(generators.Walk(dir, generators.WalkOptions{Dirs: true, Glob: "sub"})))
+	pipelinetest.AssertOrder(t, got, []interface{}{"sub"})
+}
+
+func TestWalkNew(t *testing.T) {
+	dir := tree(t)
+
+	g := generators.Walk(dir, generators.WalkOptions{
+		Glob: "a.txt",
+		New:  func(path string, info os.FileInfo) interface{} { return info.Size() },
+	})
+	pipelinetest.AssertOrder(t, drain(g), []interface{}{int64(5)})
+}
+
+func TestWalkAbort(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	g := generators.Walk(tree(t), generators.WalkOptions{})
+	if job := g.Next(); job == nil {
+		t.Fatalf(`expected a job`)
+	}
+	g.Abort()
+	if job := g.Next(); job != nil {
+		t.Errorf(`expected nil after Abort, got %v`, job)
+	}
+}
+
+func TestWalkMissing(t *testing.T) {
+	g := generators.Walk(filepath.Join(t.TempDir(), "missing"), generators.WalkOptions{})
+	if got := drain(g); len(got) != 0 {
+		t.Errorf(`expected no jobs, got %v`, got)
+	}
+	if g.Err() == nil {
+		t.Errorf(`error should not be nil`)
+	}
+}
+
+func TestTicker(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	g := generators.Ticker(time.Minute)
+	g.SetClock(clock)
+
+	for i := 1; i <= 3; i++ {
+		next := make(chan interface{})
+		go func() {
+			next <- g.Next()
+		}()
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+
+		job := <-next
+		if want := time.Unix(0, 0).Add(time.Duration(i) * time.Minute); !job.(time.Time).Equal(want) {
+			t.Errorf(`expected %v, got %v`, want, job)
+		}
+	}
+
+	abortsPromptly(t, g)
+}
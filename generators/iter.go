@@ -0,0 +1,73 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build go1.23
+
+package generators
+
+import "iter"
+
+// Pair is the job generated by Seq2
+type Pair[K, V any] struct {
+	_     struct{}
+	Key   K
+	Value V
+}
+
+// Seq generates the values of seq. Abort stops the iteration: the next call
+// to yield returns false. A nil value would end the pipeline and is skipped.
+func Seq[V any](seq iter.Seq[V]) *Generator {
+	return Func(func(yield func(interface{}) bool) error {
+		for v := range seq {
+			if !yield(v) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Seq2 generates a Pair for each key and value of seq
+func Seq2[K, V any](seq iter.Seq2[K, V]) *Generator {
+	return Func(func(yield func(interface{}) bool) error {
+		for k, v := range seq {
+			if !yield(Pair[K, V]{Key: k, Value: v}) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Func generates the jobs passed to yield by produce. yield returns false once
+// the generator is aborted and produce should then return. An error returned
+// by produce ends the generator and is reported by Err. A nil job is skipped.
+func Func(produce func(yield func(interface{}) bool) error) *Generator {
+	return newGenerator("Func", func(g *Generator) error {
+		return produce(func(job interface{}) bool {
+			if job == nil {
+				return !g.aborted()
+			}
+			return g.emit(job)
+		})
+	})
+}
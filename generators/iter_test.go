@@ -0,0 +1,100 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build go1.23
+
+package generators_test
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/jboelter/pipeline/generators"
+	"github.com/jboelter/pipeline/pipelinetest"
+)
+
+func TestSeq(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	got := drain(generators.Seq(slices.Values([]string{"a", "b", "c"})))
+	pipelinetest.AssertOrder(t, got, []interface{}{"a", "b", "c"})
+}
+
+func TestSeqAbort(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	stopped := make(chan struct{})
+	g := generators.Seq(func(yield func(int) bool) {
+		defer close(stopped)
+		for i := 0; yield(i); i++ {
+		}
+	})
+
+	if job := g.Next(); job != 0 {
+		t.Errorf(`expected 0, got %v`, job)
+	}
+	g.Abort()
+	<-stopped
+	if job := g.Next(); job != nil {
+		t.Errorf(`expected nil after Abort, got %v`, job)
+	}
+}
+
+func TestSeq2(t *testing.T) {
+	got := drain(generators.Seq2(maps.All(map[string]int{"a": 1})))
+	want := generators.Pair[string, int]{Key: "a", Value: 1}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf(`expected %v, got %v`, want, got)
+	}
+}
+
+func TestFunc(t *testing.T) {
+	errDone := errors.New("done")
+	g := generators.Func(func(yield func(interface{}) bool) error {
+		for _, job := range []interface{}{1, nil, 2} {
+			if !yield(job) {
+				return nil
+			}
+		}
+		return errDone
+	})
+
+	pipelinetest.AssertOrder(t, drain(g), []interface{}{1, 2})
+	if g.Err() != errDone {
+		t.Errorf(`expected %v, got %v`, errDone, g.Err())
+	}
+}
+
+func TestFuncAbort(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	// a producer blocked elsewhere does not hold up Abort
+	release := make(chan struct{})
+	defer close(release)
+
+	abortsPromptly(t, generators.Func(func(yield func(interface{}) bool) error {
+		<-release
+		return nil
+	}))
+}
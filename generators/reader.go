@@ -0,0 +1,128 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package generators
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+// Lines generates each line of r as a string, without the line ending. A
+// goroutine blocked reading r is left behind by Abort until the read returns.
+func Lines(r io.Reader) *Generator {
+	return newGenerator("Lines", func(g *Generator) error {
+		return readLines(g, r, func(line []byte) (interface{}, error) {
+			return string(line), nil
+		})
+	})
+}
+
+// JSONL generates one job per line of r, decoded with encoding/json into the
+// value returned by newJob. A nil newJob decodes into map[string]interface{}.
+// Blank lines are skipped.
+func JSONL(r io.Reader, newJob func() interface{}) *Generator {
+	if newJob == nil {
+		newJob = func() interface{} { return &map[string]interface{}{} }
+	}
+	return newGenerator("JSONL", func(g *Generator) error {
+		return readLines(g, r, func(line []byte) (interface{}, error) {
+			if len(line) == 0 {
+				return nil, nil
+			}
+			job := newJob()
+			if err := json.Unmarshal(line, job); err != nil {
+				return nil, err
+			}
+			if m, ok := job.(*map[string]interface{}); ok {
+				return *m, nil
+			}
+			return job, nil
+		})
+	})
+}
+
+// CSV generates each record of r. Without a header a record is a []string;
+// with a header the first record names the fields and each following record
+// is a map[string]string.
+func CSV(r io.Reader, header bool) *Generator {
+	return newGenerator("CSV", func(g *Generator) error {
+		rd := csv.NewReader(r)
+		var fields []string
+
+		return read(g, func() (interface{}, error) {
+			record, err := rd.Read()
+			if err != nil {
+				return nil, err
+			}
+			if !header {
+				return record, nil
+			}
+			if fields == nil {
+				fields = record
+				return nil, nil
+			}
+			m := make(map[string]string, len(fields))
+			for i, f := range fields {
+				if i < len(record) {
+					m[f] = record[i]
+				}
+			}
+			return m, nil
+		})
+	})
+}
+
+func readLines(g *Generator, r io.Reader, decode func(line []byte) (interface{}, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	return read(g, func() (interface{}, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		return decode(scanner.Bytes())
+	})
+}
+
+// read emits the jobs returned by next until io.EOF, an error or an abort. A
+// nil job is skipped.
+func read(g *Generator, next func() (interface{}, error)) error {
+	for !g.aborted() {
+		job, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if job != nil && !g.emit(job) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package generators
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// errAborted stops a walk once the generator is aborted
+var errAborted = errors.New("generators: aborted")
+
+// WalkOptions filters the entries generated by Walk. Glob and Match are
+// applied to the base name of each entry; an entry must satisfy both when
+// both are set. New creates the job for an entry and defaults to the path.
+type WalkOptions struct {
+	_     struct{}
+	Glob  string
+	Match *regexp.Regexp
+	Dirs  bool
+	New   func(path string, info os.FileInfo) interface{}
+}
+
+// Walk generates the files under root in lexical order. Entries that cannot be
+// read are skipped; the first such error is reported by Err once the walk is
+// complete.
+func Walk(root string, opts WalkOptions) *Generator {
+	if opts.New == nil {
+		opts.New = func(path string, info os.FileInfo) interface{} { return path }
+	}
+
+	return newGenerator("Walk", func(g *Generator) error {
+		var first error
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if g.aborted() {
+				return errAborted
+			}
+			if err != nil {
+				if first == nil {
+					first = err
+				}
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if info.IsDir() && !opts.Dirs {
+				return nil
+			}
+			if opts.Glob != "" {
+				matched, err := filepath.Match(opts.Glob, info.Name())
+				if err != nil {
+					return err
+				}
+				if !matched {
+					return nil
+				}
+			}
+			if opts.Match != nil && !opts.Match.MatchString(info.Name()) {
+				return nil
+			}
+
+			if !g.emit(opts.New(path, info)) {
+				return errAborted
+			}
+			return nil
+		})
+
+		if err == errAborted {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return first
+	})
+}
+
+// Ticker generates the current time every interval until aborted. The time
+// comes from the pipeline's Clock.
+func Ticker(interval time.Duration) *Generator {
+	return newGenerator("Ticker", func(g *Generator) error {
+		for {
+			t := g.getClock().NewTimer(interval)
+			select {
+			case now := <-t.C():
+				if !g.emit(now) {
+					return nil
+				}
+			case <-g.quit:
+				t.Stop()
+				return nil
+			}
+		}
+	})
+}
@@ -0,0 +1,126 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DOT renders the pipeline topology in the Graphviz DOT language. Each stage
+// is labeled with its concurrency and each edge with the capacity of the
+// queue. While the pipeline is running the processed, in-flight and queued
+// counts are included.
+func (p *Pipeline) DOT() string {
+	var b bytes.Buffer
+	b.WriteString("digraph pipeline {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box];\n")
+
+	nodes, edges := p.topology()
+	for i, n := range nodes {
+		shape := ""
+		if i == 0 {
+			shape = " shape=ellipse"
+		}
+		fmt.Fprintf(&b, "\tn%d [label=%s%s];\n", i, dotQuote(n), shape)
+	}
+	for i, e := range edges {
+		fmt.Fprintf(&b, "\tn%d -> n%d [label=%s];\n", i, i+1, dotQuote(e))
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the pipeline topology as a Mermaid flowchart with the same
+// labels as DOT.
+func (p *Pipeline) Mermaid() string {
+	var b bytes.Buffer
+	b.WriteString("flowchart LR\n")
+
+	nodes, edges := p.topology()
+	for i, n := range nodes {
+		label := mermaidQuote(n)
+		if i == 0 {
+			fmt.Fprintf(&b, "\tn%d([%s])\n", i, label)
+		} else {
+			fmt.Fprintf(&b, "\tn%d[%s]\n", i, label)
+		}
+	}
+	for i, e := range edges {
+		fmt.Fprintf(&b, "\tn%d -->|%s| n%d\n", i, mermaidQuote(e), i+1)
+	}
+	return b.String()
+}
+
+// topology returns the node labels, generator first, and the labels of the
+// edges between consecutive nodes; lines within a label are separated by \n
+func (p *Pipeline) topology() (nodes []string, edges []string) {
+	snap := p.Snapshot()
+
+	generator := snap.Generator
+	if generator == "" {
+		generator = "(no generator)"
+	}
+	nodes = append(nodes, generator)
+
+	for idx, ss := range snap.Stages {
+		workers := ss.Workers
+		if !snap.Running {
+			workers = p.concurrency(p.runners[idx])
+		}
+
+		label := fmt.Sprintf("%s\nconcurrency=%d", ss.Name, workers)
+		edge := "unbounded"
+		if ss.Capacity >= 0 {
+			edge = fmt.Sprintf("depth=%d", ss.Capacity)
+		}
+
+		if snap.Running {
+			label += fmt.Sprintf("\nprocessed=%d\nin flight=%d", ss.Processed, ss.InFlight)
+			edge += fmt.Sprintf("\nqueued=%d", ss.Queued)
+			if ss.Paused {
+				label += "\npaused"
+			}
+		}
+
+		nodes = append(nodes, label)
+		edges = append(edges, edge)
+	}
+	return nodes, edges
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+func mermaidQuote(s string) string {
+	s = strings.Replace(s, `"`, "#quot;", -1)
+	s = strings.Replace(s, "\n", "<br/>", -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,77 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"testing"
+
+	"github.com/jboelter/pipeline"
+)
+
+type QuotedStage struct {
+	CountingStage
+}
+
+func (s *QuotedStage) Name() string {
+	return `say "hi"`
+}
+
+func (s *QuotedStage) Concurrency() int {
+	return 4
+}
+
+func graphPipeline() *pipeline.Pipeline {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{}, &QuotedStage{})
+	return p
+}
+
+func TestDOT(t *testing.T) {
+	expected := `digraph pipeline {
+	rankdir=LR;
+	node [shape=box];
+	n0 [label="CountsToTenGenerator" shape=ellipse];
+	n1 [label="CountingStage\nconcurrency=1"];
+	n2 [label="say \"hi\"\nconcurrency=4"];
+	n0 -> n1 [label="depth=1"];
+	n1 -> n2 [label="depth=10"];
+}
+`
+	if dot := graphPipeline().DOT(); dot != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, dot)
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	expected := `flowchart LR
+	n0(["CountsToTenGenerator"])
+	n1["CountingStage<br/>concurrency=1"]
+	n2["say #quot;hi#quot;<br/>concurrency=4"]
+	n0 -->|"depth=1"| n1
+	n1 -->|"depth=10"| n2
+`
+	if m := graphPipeline().Mermaid(); m != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, m)
+	}
+}
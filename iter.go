@@ -0,0 +1,47 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build go1.23
+
+package pipeline
+
+import "iter"
+
+// Results runs the pipeline and yields each job that leaves the final stage.
+// Breaking out of the loop aborts the pipeline; the iteration still waits for
+// the jobs already in flight to drain so that no goroutine is left behind. If
+// the pipeline cannot be started the error is yielded once with a nil job.
+//
+//	for job, err := range p.Results() {
+//		...
+//	}
+func (p *Pipeline) Results() iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		if err := p.start(); err != nil {
+			yield(nil, err)
+			return
+		}
+		p.drain(func(job interface{}) bool {
+			return yield(job, nil)
+		})
+	}
+}
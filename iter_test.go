@@ -0,0 +1,87 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build go1.23
+
+package pipeline_test
+
+import (
+	"testing"
+
+	"github.com/jboelter/pipeline"
+)
+
+func TestResults(t *testing.T) {
+	cfg := pipeline.DefaultConfig()
+	cfg.NoConcurrency = true
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{})
+
+	want := 1
+	for job, err := range p.Results() {
+		if err != nil {
+			t.Fatalf(`error should be nil, got %v`, err)
+		}
+		if job != want {
+			t.Errorf(`expected %v, got %v`, want, job)
+		}
+		want++
+	}
+	if want != 11 {
+		t.Errorf(`expected 10 results, got %v`, want-1)
+	}
+}
+
+func TestResultsBreak(t *testing.T) {
+	generator := &AbortableGenerator{QuitChan: make(chan struct{})}
+	p := pipeline.New()
+	p.SetGenerator(generator)
+	p.AddStage(&CountingStage{})
+
+	n := 0
+	for range p.Results() {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf(`expected 1 result, got %v`, n)
+	}
+	if generator.AbortCount != 1 {
+		t.Errorf(`generator should be aborted once, got %v`, generator.AbortCount)
+	}
+}
+
+func TestResultsError(t *testing.T) {
+	p := pipeline.New()
+
+	n := 0
+	for job, err := range p.Results() {
+		n++
+		if job != nil || err != pipeline.ErrNilGenerator {
+			t.Errorf(`expected %v, got %v, %v`, pipeline.ErrNilGenerator, job, err)
+		}
+	}
+	if n != 1 {
+		t.Errorf(`expected the error once, got %v`, n)
+	}
+}
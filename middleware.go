@@ -0,0 +1,290 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrPanic is returned by a stage wrapped with Recover when processing a job
+// panics.
+var ErrPanic = errors.New("pipeline: the stage panicked")
+
+// Middleware wraps a stage to add behavior around the processing of each job,
+// such as logging, timing or recovering from panics. Middlewares set in
+// Config.Middleware apply to every stage added afterwards and those in
+// StageOptions.Middleware to a single stage; the first middleware listed is
+// the outermost and the global middlewares wrap those of the stage.
+type Middleware func(Stage) Stage
+
+// Invocation is the processing of one job by a stage, as seen by an
+// Interceptor. Stage is the stage being wrapped and Clock is the pipeline's.
+// Envelope holds the metadata of the job when Config.Metadata is set and the
+// stage is not an Emitter; otherwise it is nil. Job is nil when the stage is
+// a Flusher being flushed.
+type Invocation struct {
+	_        struct{}
+	Stage    Stage
+	Job      interface{}
+	Envelope *Envelope
+	Clock    Clock
+	next     func() error
+}
+
+// Proceed processes the job with the wrapped stage and returns its error, if
+// the stage is Retryable
+func (inv *Invocation) Proceed() error {
+	return inv.next()
+}
+
+// Interceptor runs around the processing of a job; it calls Proceed to
+// process the job and returns the error to report for it
+type Interceptor func(inv *Invocation) error
+
+// Intercept returns a Middleware that calls fn for each job. The stage it
+// returns keeps the name, concurrency and optional interfaces (Retryable,
+// Emitter, Flusher, EnvelopeProcessor, Clocked and Seeded) of the stage it
+// wraps, so it can be used to write middlewares that apply to any stage.
+func Intercept(fn Interceptor) Middleware {
+	return func(s Stage) Stage {
+		return intercept(s, fn, "")
+	}
+}
+
+// intercept wraps s with fn; a name other than "" replaces the stage's own
+func intercept(s Stage, fn Interceptor, name string) Stage {
+	w := &intercepted{stage: s, fn: fn, name: name, clock: SystemClock{}}
+	_, emits := s.(Emitter)
+	_, flushes := s.(Flusher)
+	switch {
+	case emits && flushes:
+		return &interceptedEmitFlusher{intercepted: w}
+	case emits:
+		return &interceptedEmitter{intercepted: w}
+	case flushes:
+		return &interceptedFlusher{intercepted: w}
+	}
+	return w
+}
+
+// Timing is a Middleware that reports the time taken to process each job, as
+// measured by the pipeline's clock
+func Timing(observe func(stage string, d time.Duration)) Middleware {
+	return Intercept(func(inv *Invocation) error {
+		start := inv.Clock.Now()
+		err := inv.Proceed()
+		observe(inv.Stage.Name(), inv.Clock.Now().Sub(start))
+		return err
+	})
+}
+
+// Logging is a Middleware that logs each job processed with the time taken
+// and the error, if any
+func Logging(logger *log.Logger) Middleware {
+	return Intercept(func(inv *Invocation) error {
+		start := inv.Clock.Now()
+		err := inv.Proceed()
+		if err != nil {
+			logger.Printf("source=pipeline, stage='%v', action=processed, elapsed=%v, error='%v'\n", inv.Stage.Name(), inv.Clock.Now().Sub(start), err)
+		} else {
+			logger.Printf("source=pipeline, stage='%v', action=processed, elapsed=%v\n", inv.Stage.Name(), inv.Clock.Now().Sub(start))
+		}
+		return err
+	})
+}
+
+// Recover is a Middleware that recovers from a panic while processing a job,
+// or while an Emitter or Flusher emits jobs, and reports it as an error
+// wrapping ErrPanic, which the pipeline counts and logs like any other
+func Recover() Middleware {
+	return Intercept(func(inv *Invocation) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%w: %v", ErrPanic, r)
+			}
+		}()
+		return inv.Proceed()
+	})
+}
+
+// chain wraps s with the middlewares, the first being the outermost
+func chain(s Stage, mws ...[]Middleware) Stage {
+	for i := len(mws) - 1; i >= 0; i-- {
+		for j := len(mws[i]) - 1; j >= 0; j-- {
+			s = mws[i][j](s)
+		}
+	}
+	return s
+}
+
+// tryEmitter and tryFlusher are implemented by the stages Intercept returns so
+// that the error of an interceptor around Emit or Flush reaches the pipeline
+type tryEmitter interface {
+	tryEmit(job interface{}, emit func(interface{})) error
+}
+
+type tryFlusher interface {
+	tryFlush(emit func(interface{})) error
+}
+
+// intercepted is the Stage returned by Intercept
+type intercepted struct {
+	_     struct{}
+	stage Stage
+	fn    Interceptor
+	name  string
+	mu    sync.Mutex
+	clock Clock
+}
+
+func (w *intercepted) Name() string {
+	if w.name != "" {
+		return w.name
+	}
+	return w.stage.Name()
+}
+
+func (w *intercepted) Concurrency() int {
+	return w.stage.Concurrency()
+}
+
+func (w *intercepted) Process(job interface{}) {
+	w.TryProcess(job)
+}
+
+func (w *intercepted) TryProcess(job interface{}) error {
+	return w.invoke(job, nil, func() error {
+		return w.call(job)
+	})
+}
+
+func (w *intercepted) ProcessEnvelope(e *Envelope) error {
+	return w.invoke(e.Job, e, func() error {
+		if ep, ok := w.stage.(EnvelopeProcessor); ok {
+			return ep.ProcessEnvelope(e)
+		}
+		return w.call(e.Job)
+	})
+}
+
+// call processes the job with the wrapped stage
+func (w *intercepted) call(job interface{}) error {
+	if r, ok := w.stage.(Retryable); ok {
+		return r.TryProcess(job)
+	}
+	w.stage.Process(job)
+	return nil
+}
+
+func (w *intercepted) invoke(job interface{}, e *Envelope, next func() error) error {
+	w.mu.Lock()
+	clock := w.clock
+	w.mu.Unlock()
+	return w.fn(&Invocation{Stage: w.stage, Job: job, Envelope: e, Clock: clock, next: next})
+}
+
+func (w *intercepted) SetClock(c Clock) {
+	w.mu.Lock()
+	w.clock = c
+	w.mu.Unlock()
+	if clocked, ok := w.stage.(Clocked); ok {
+		clocked.SetClock(c)
+	}
+}
+
+func (w *intercepted) SetRand(r *rand.Rand) {
+	if seeded, ok := w.stage.(Seeded); ok {
+		seeded.SetRand(r)
+	}
+}
+
+// callEmit emits the job with the wrapped Emitter
+func (w *intercepted) callEmit(job interface{}, emit func(interface{})) error {
+	return w.invoke(job, nil, func() error {
+		if e, ok := w.stage.(tryEmitter); ok {
+			return e.tryEmit(job, emit)
+		}
+		w.stage.(Emitter).Emit(job, emit)
+		return nil
+	})
+}
+
+// callFlush flushes the wrapped Flusher
+func (w *intercepted) callFlush(emit func(interface{})) error {
+	return w.invoke(nil, nil, func() error {
+		if f, ok := w.stage.(tryFlusher); ok {
+			return f.tryFlush(emit)
+		}
+		w.stage.(Flusher).Flush(emit)
+		return nil
+	})
+}
+
+type interceptedEmitter struct {
+	*intercepted
+}
+
+func (w *interceptedEmitter) Emit(job interface{}, emit func(interface{})) {
+	w.callEmit(job, emit)
+}
+
+func (w *interceptedEmitter) tryEmit(job interface{}, emit func(interface{})) error {
+	return w.callEmit(job, emit)
+}
+
+type interceptedFlusher struct {
+	*intercepted
+}
+
+func (w *interceptedFlusher) Flush(emit func(interface{})) {
+	w.callFlush(emit)
+}
+
+func (w *interceptedFlusher) tryFlush(emit func(interface{})) error {
+	return w.callFlush(emit)
+}
+
+type interceptedEmitFlusher struct {
+	*intercepted
+}
+
+func (w *interceptedEmitFlusher) Emit(job interface{}, emit func(interface{})) {
+	w.callEmit(job, emit)
+}
+
+func (w *interceptedEmitFlusher) tryEmit(job interface{}, emit func(interface{})) error {
+	return w.callEmit(job, emit)
+}
+
+func (w *interceptedEmitFlusher) Flush(emit func(interface{})) {
+	w.callFlush(emit)
+}
+
+func (w *interceptedEmitFlusher) tryFlush(emit func(interface{})) error {
+	return w.callFlush(emit)
+}
@@ -0,0 +1,282 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+// tracing returns a middleware that records its name around each job
+func tracing(name string, mu *sync.Mutex, trace *[]string) pipeline.Middleware {
+	return pipeline.Intercept(func(inv *pipeline.Invocation) error {
+		mu.Lock()
+		*trace = append(*trace, name+">")
+		mu.Unlock()
+		err := inv.Proceed()
+		mu.Lock()
+		*trace = append(*trace, "<"+name)
+		mu.Unlock()
+		return err
+	})
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var mu sync.Mutex
+	var trace []string
+
+	cfg := pipeline.DefaultConfig()
+	cfg.Middleware = []pipeline.Middleware{tracing("global", &mu, &trace)}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&OneJobGenerator{})
+	p.AddStageWithOptions(&CountingStage{}, pipeline.StageOptions{
+		Middleware: []pipeline.Middleware{tracing("a", &mu, &trace), tracing("b", &mu, &trace)},
+	})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if got := strings.Join(trace, " "); got != "global> a> b> <b <a <global" {
+		t.Errorf("unexpected order %v", got)
+	}
+	if p.Snapshot().Stages[0].Name != "CountingStage" {
+		t.Errorf("expected the name of the wrapped stage")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	counting := &CountingStage{}
+	cfg := pipeline.DefaultConfig()
+	cfg.Middleware = []pipeline.Middleware{pipeline.Recover()}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&PanickingStage{On: 3})
+	p.AddStage(counting)
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if errs := p.Snapshot().Stages[0].Errors; errs != 1 || counting.ProcessCount != 10 {
+		t.Errorf("expected 1 error and 10 jobs downstream; errors=%v, processed=%v", errs, counting.ProcessCount)
+	}
+}
+
+func TestTimingAndLogging(t *testing.T) {
+	var buf bytes.Buffer
+	var durations []time.Duration
+
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	cfg.Middleware = []pipeline.Middleware{
+		pipeline.Logging(log.New(&buf, "", 0)),
+		pipeline.Timing(func(stage string, d time.Duration) {
+			durations = append(durations, d)
+		}),
+	}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&SleepingStage{Sleep: time.Second})
+
+	if err := p.Simulate(1); err != nil {
+		t.Fatal(err)
+	}
+	if len(durations) != 10 || durations[0] != time.Second {
+		t.Errorf("expected 10 one second jobs, got %v", durations)
+	}
+	if n := strings.Count(buf.String(), "stage='SleepingStage', action=processed, elapsed=1s"); n != 10 {
+		t.Errorf("expected 10 log lines, got %v\n%v", n, buf.String())
+	}
+}
+
+func TestMiddlewareKeepsInterfaces(t *testing.T) {
+	var calls int
+	count := pipeline.Intercept(func(inv *pipeline.Invocation) error {
+		calls++
+		return inv.Proceed()
+	})
+
+	// retried through the middleware
+	flaky := &FlakyStage{Failures: 1}
+	p := pipeline.New()
+	p.SetGenerator(&OneJobGenerator{})
+	p.AddStageWithOptions(flaky, pipeline.StageOptions{Retries: 1, Middleware: []pipeline.Middleware{count}})
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if flaky.Attempts() != 2 || calls != 2 || p.Snapshot().Stages[0].Errors != 0 {
+		t.Errorf("expected the job to be retried once; attempts=%v, calls=%v", flaky.Attempts(), calls)
+	}
+
+	// still emits and flushes
+	calls = 0
+	var out []interface{}
+	cfg := pipeline.DefaultConfig()
+	cfg.Output = func(job interface{}) {
+		out = append(out, job)
+	}
+	p = pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&OneJobGenerator{})
+	p.AddStageWithOptions(&EmittingStage{}, pipeline.StageOptions{Middleware: []pipeline.Middleware{count}})
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	// the interceptor runs around the Emit and the Flush
+	if calls != 2 || len(out) != 3 || out[2] != "flushed" {
+		t.Errorf("expected the job twice and a flush; calls=%v, out=%v", calls, out)
+	}
+
+	if _, ok := count(&CountingStage{}).(pipeline.Emitter); ok {
+		t.Errorf("a wrapped stage should only be an Emitter if the stage is")
+	}
+}
+
+func TestRecoverEmitter(t *testing.T) {
+	var jobs []interface{}
+	cfg := pipeline.DefaultConfig()
+	cfg.Output = func(job interface{}) {
+		jobs = append(jobs, job)
+	}
+	// the panics pass through the inner middleware to Recover
+	cfg.Middleware = []pipeline.Middleware{pipeline.Recover(), pipeline.Timing(func(string, time.Duration) {})}
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&PanickingEmitter{On: 3})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	// job 3 and the flush panic; the other jobs are emitted
+	if errs := p.Snapshot().Stages[0].Errors; errs != 2 {
+		t.Errorf("expected 2 errors, got %v", errs)
+	}
+	if len(jobs) != 9 {
+		t.Errorf("expected 9 jobs, got %v", jobs)
+	}
+}
+
+func TestPanicError(t *testing.T) {
+	s := pipeline.Recover()(&PanickingStage{On: 1}).(pipeline.Retryable)
+	if err := s.TryProcess(1); !errors.Is(err, pipeline.ErrPanic) || !strings.Contains(err.Error(), "job 1") {
+		t.Errorf("expected ErrPanic, got %v", err)
+	}
+}
+
+/* test generator */
+type OneJobGenerator struct {
+	done bool
+}
+
+func (g *OneJobGenerator) Name() string {
+	return "OneJobGenerator"
+}
+
+func (g *OneJobGenerator) Next() interface{} {
+	if g.done {
+		return nil
+	}
+	g.done = true
+	return 1
+}
+
+func (g *OneJobGenerator) Abort() {
+}
+
+/* test stage */
+type PanickingStage struct {
+	On int
+}
+
+func (s *PanickingStage) Name() string {
+	return "PanickingStage"
+}
+
+func (s *PanickingStage) Concurrency() int {
+	return 2
+}
+
+func (s *PanickingStage) Process(job interface{}) {
+	if job == s.On {
+		panic(fmt.Sprintf("job %v", job))
+	}
+}
+
+/* test stage */
+type EmittingStage struct{}
+
+func (s *EmittingStage) Name() string {
+	return "EmittingStage"
+}
+
+func (s *EmittingStage) Concurrency() int {
+	return 1
+}
+
+func (s *EmittingStage) Process(interface{}) {
+}
+
+func (s *EmittingStage) Emit(job interface{}, emit func(interface{})) {
+	emit(job)
+	emit(job)
+}
+
+func (s *EmittingStage) Flush(emit func(interface{})) {
+	emit("flushed")
+}
+
+/* test stage */
+type PanickingEmitter struct {
+	On int
+}
+
+func (s *PanickingEmitter) Name() string {
+	return "PanickingEmitter"
+}
+
+func (s *PanickingEmitter) Concurrency() int {
+	return 1
+}
+
+func (s *PanickingEmitter) Process(interface{}) {
+}
+
+func (s *PanickingEmitter) Emit(job interface{}, emit func(interface{})) {
+	if job == s.On {
+		panic(fmt.Sprintf("job %v", job))
+	}
+	emit(job)
+}
+
+func (s *PanickingEmitter) Flush(emit func(interface{})) {
+	panic("flush")
+}
@@ -0,0 +1,206 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"sync"
+)
+
+// Overflow defines what a BoundedQueue does with a job Put while it is full
+type Overflow int
+
+const (
+	// Block waits for room in the queue; the behavior of a channel
+	Block Overflow = iota
+	// DropNewest discards the job being Put
+	DropNewest
+	// DropOldest discards the oldest waiting job to make room
+	DropOldest
+	// Spill moves the job to a secondary (typically disk backed) queue
+	Spill
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Spill:
+		return "spill"
+	}
+	return "unknown"
+}
+
+// OverflowCounter may be implemented by a Queue that drops or spills jobs
+// rather than blocking. The counts are reported by Pipeline.Stats.
+type OverflowCounter interface {
+	Dropped() uint64
+	Spilled() uint64
+}
+
+// BoundedQueue is an in-memory Queue holding up to capacity jobs that applies
+// an Overflow policy once it is full.
+//
+// With the Spill policy, jobs that do not fit are Put on the spill queue and
+// every later job follows them until the spill queue has drained, keeping the
+// queue FIFO. Jobs already in the spill queue, such as those a diskqueue
+// recovers from an earlier run, are delivered first. Spilled jobs are
+// acknowledged on the spill queue as soon as they are taken back out.
+type BoundedQueue struct {
+	_        struct{}
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	ring     *RingQueue
+	policy   Overflow
+	spill    Queue
+	spilling int // jobs waiting in the spill queue
+	dropped  uint64
+	spilled  uint64
+	closed   bool
+}
+
+// NewBoundedQueue creates a BoundedQueue. The spill queue is only used, and
+// must be non-nil, with the Spill policy; it may already hold jobs.
+func NewBoundedQueue(capacity int, policy Overflow, spill Queue) *BoundedQueue {
+	if policy == Spill && spill == nil {
+		panic("pipeline: the Spill policy requires a spill queue")
+	}
+	q := &BoundedQueue{
+		ring:   NewRingQueue(capacity),
+		policy: policy,
+		spill:  spill,
+	}
+	if policy == Spill {
+		q.spilling = spill.Len()
+	}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// Put implements Queue
+func (q *BoundedQueue) Put(job interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	full := func() bool { return q.ring.Len() == q.ring.Cap() }
+
+	switch {
+	case q.policy == Spill && (q.spilling > 0 || full()):
+		if err := q.spill.Put(job); err != nil {
+			return err
+		}
+		q.spilling++
+		q.spilled++
+		q.notEmpty.Signal()
+		return nil
+	case q.policy == DropNewest && full():
+		q.dropped++
+		return nil
+	case q.policy == DropOldest && full():
+		q.ring.Get()
+		q.dropped++
+	}
+
+	for full() {
+		q.notFull.Wait()
+	}
+	q.ring.Put(job)
+	q.notEmpty.Signal()
+	return nil
+}
+
+// Get implements Queue
+func (q *BoundedQueue) Get() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.ring.Len() > 0 {
+			job, _ := q.ring.Get()
+			q.notFull.Signal()
+			return job, true
+		}
+
+		if q.spilling > 0 {
+			// only this queue takes from the spill queue so it will not block
+			job, ok := q.spill.Get()
+			if ok {
+				q.spilling--
+				ack(q.spill, job)
+				return job, true
+			}
+			q.spilling = 0
+		}
+
+		if q.closed {
+			return nil, false
+		}
+		q.notEmpty.Wait()
+	}
+}
+
+// Close implements Queue
+func (q *BoundedQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	if q.spill != nil {
+		q.spill.Close()
+	}
+	q.notEmpty.Broadcast()
+}
+
+// Len implements Queue; spilled jobs are included
+func (q *BoundedQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.ring.Len() + q.spilling
+}
+
+// Cap returns the capacity of the in-memory buffer, or -1 with the Spill
+// policy which is bounded only by the spill queue
+func (q *BoundedQueue) Cap() int {
+	if q.policy == Spill {
+		return -1
+	}
+	return q.ring.Cap()
+}
+
+// Dropped implements OverflowCounter
+func (q *BoundedQueue) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Spilled implements OverflowCounter
+func (q *BoundedQueue) Spilled() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.spilled
+}
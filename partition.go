@@ -0,0 +1,89 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+// ErrPartitioned is returned by Resize for a partitioned stage, whose number
+// of workers is fixed while it runs.
+var ErrPartitioned = errors.New("pipeline: a partitioned stage cannot be resized")
+
+// Keyed may be implemented by a job to name the partition it belongs to. A
+// partitioned stage processes the jobs with the same key on the same worker,
+// one at a time and in the order they arrive.
+type Keyed interface {
+	Key() string
+}
+
+func (r *runner) partitioned() bool {
+	return r.opts.Partitioned || r.opts.Key != nil
+}
+
+// partition returns the worker, of n, for the job. Jobs without a key are
+// spread over the workers in turn.
+func (r *runner) partition(job interface{}, n int) int {
+	job = Unwrap(job)
+
+	var key string
+	switch {
+	case r.opts.Key != nil:
+		key = r.opts.Key(job)
+	case isKeyed(job):
+		key = job.(Keyed).Key()
+	default:
+		r.next = (r.next + 1) % n
+		return r.next
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+func isKeyed(job interface{}) bool {
+	_, ok := job.(Keyed)
+	return ok
+}
+
+// dispatch hands each job from the input of a partitioned stage to the
+// worker for its key
+func (p *Pipeline) dispatch(r *runner) {
+	defer func() {
+		for _, c := range r.parts {
+			close(c)
+		}
+	}()
+
+	for {
+		r.gate.wait()
+
+		job, ok := r.in.Get()
+		if !ok {
+			return
+		}
+		r.parts[r.partition(job, len(r.parts))] <- job
+	}
+}
@@ -0,0 +1,299 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+func TestPartitioned(t *testing.T) {
+	stage := &SerialStage{}
+	p := pipeline.New()
+	p.SetGenerator(&KeyedGenerator{Keys: 5, Jobs: 100})
+	p.AddStageWithOptions(stage, pipeline.StageOptions{Partitioned: true})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	stage.check(t, 5, 100)
+}
+
+func TestPartitionKey(t *testing.T) {
+	stage := &SerialStage{}
+	p := pipeline.New()
+	p.SetGenerator(&KeyedGenerator{Keys: 5, Jobs: 100})
+	p.AddStageWithOptions(stage, pipeline.StageOptions{
+		Key: func(job interface{}) string {
+			// fold the 5 keys into 2
+			return strconv.Itoa(job.(*KeyedJob).ID % 2)
+		},
+	})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if stage.overlaps != 0 {
+		t.Errorf("expected the jobs for a key to be processed one at a time, got %v overlaps", stage.overlaps)
+	}
+}
+
+func TestPartitionedResize(t *testing.T) {
+	stage := &BlockingStage{Started: make(chan struct{}), Release: make(chan struct{})}
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStageWithOptions(stage, pipeline.StageOptions{Partitioned: true})
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+	<-stage.Started
+
+	if err := p.Resize("BlockingStage", 4); err != pipeline.ErrPartitioned {
+		t.Errorf("expected ErrPartitioned, got %v", err)
+	}
+
+	close(stage.Release)
+	h.Wait()
+}
+
+func TestSimulatePartitioned(t *testing.T) {
+	stage := &SerialStage{}
+	p := pipeline.New()
+	p.SetGenerator(&KeyedGenerator{Keys: 3, Jobs: 30})
+	p.AddStageWithOptions(stage, pipeline.StageOptions{Partitioned: true})
+
+	if err := p.Simulate(7); err != nil {
+		t.Fatal(err)
+	}
+	stage.check(t, 3, 30)
+}
+
+func TestPartitionedTimeout(t *testing.T) {
+	stage := &SlowKeyedStage{}
+	p := pipeline.New()
+	p.SetGenerator(&KeyedGenerator{Keys: 1, Jobs: 3})
+	p.AddStageWithOptions(stage, pipeline.StageOptions{Partitioned: true, Concurrency: 2, Timeout: 5 * time.Millisecond})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if stage.most != 1 {
+		t.Errorf("expected the jobs for the key to be processed one at a time, got %v at once", stage.most)
+	}
+	if errs := p.Snapshot().Stages[0].Errors; errs != 3 {
+		t.Errorf("expected 3 timeouts, got %v", errs)
+	}
+}
+
+func TestPartitionedBusyWorker(t *testing.T) {
+	// find a key for each of the 2 workers
+	keys := []string{"a"}
+	for i := 0; len(keys) < 2; i++ {
+		k := strconv.Itoa(i)
+		if worker(k, 2) != worker(keys[0], 2) {
+			keys = append(keys, k)
+		}
+	}
+
+	stage := &HoldingStage{Release: make(chan struct{})}
+	p := pipeline.New()
+	p.SetGenerator(&KeyedGenerator{Keys: 2, Jobs: 10})
+	p.AddStageWithOptions(stage, pipeline.StageOptions{
+		Key: func(job interface{}) string {
+			return keys[job.(*KeyedJob).ID]
+		},
+	})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if stage.TimedOut {
+		t.Errorf("expected the jobs for the other key to pass the busy worker")
+	}
+}
+
+// worker returns the worker a key is partitioned to
+func worker(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+/* test job */
+type KeyedJob struct {
+	ID  int
+	Seq int
+}
+
+func (j *KeyedJob) Key() string {
+	return strconv.Itoa(j.ID)
+}
+
+/* test generator */
+type KeyedGenerator struct {
+	Keys, Jobs int
+	n          int
+}
+
+func (g *KeyedGenerator) Name() string {
+	return "KeyedGenerator"
+}
+
+func (g *KeyedGenerator) Next() interface{} {
+	if g.n == g.Jobs {
+		return nil
+	}
+	job := &KeyedJob{ID: g.n % g.Keys, Seq: g.n / g.Keys}
+	g.n++
+	return job
+}
+
+func (g *KeyedGenerator) Abort() {
+}
+
+/* test stage */
+type SerialStage struct {
+	mu       sync.Mutex
+	active   map[int]bool
+	seen     map[int][]int
+	overlaps int
+}
+
+func (s *SerialStage) Name() string {
+	return "SerialStage"
+}
+
+func (s *SerialStage) Concurrency() int {
+	return 4
+}
+
+func (s *SerialStage) Process(job interface{}) {
+	j := job.(*KeyedJob)
+
+	s.mu.Lock()
+	if s.active == nil {
+		s.active, s.seen = make(map[int]bool), make(map[int][]int)
+	}
+	if s.active[j.ID] {
+		s.overlaps++
+	}
+	s.active[j.ID] = true
+	s.seen[j.ID] = append(s.seen[j.ID], j.Seq)
+	s.mu.Unlock()
+
+	time.Sleep(time.Duration(j.Seq%3) * 100 * time.Microsecond)
+
+	s.mu.Lock()
+	s.active[j.ID] = false
+	s.mu.Unlock()
+}
+
+func (s *SerialStage) check(t *testing.T, keys, jobs int) {
+	t.Helper()
+	if s.overlaps != 0 {
+		t.Errorf("expected the jobs for a key to be processed one at a time, got %v overlaps", s.overlaps)
+	}
+	if len(s.seen) != keys {
+		t.Fatalf("expected %v keys, got %v", keys, len(s.seen))
+	}
+	for id, seqs := range s.seen {
+		if len(seqs) != jobs/keys {
+			t.Errorf("key %v: expected %v jobs, got %v", id, jobs/keys, len(seqs))
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Errorf("key %v: expected the jobs in order, got %v", id, seqs)
+				break
+			}
+		}
+	}
+}
+
+/* test stage */
+type SlowKeyedStage struct {
+	mu           sync.Mutex
+	active, most int
+}
+
+func (s *SlowKeyedStage) Name() string {
+	return "SlowKeyedStage"
+}
+
+func (s *SlowKeyedStage) Concurrency() int {
+	return 1
+}
+
+func (s *SlowKeyedStage) Process(interface{}) {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.most {
+		s.most = s.active
+	}
+	s.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+}
+
+/* test stage */
+type HoldingStage struct {
+	Release  chan struct{}
+	TimedOut bool
+	others   int32
+}
+
+func (s *HoldingStage) Name() string {
+	return "HoldingStage"
+}
+
+func (s *HoldingStage) Concurrency() int {
+	return 2
+}
+
+// Process holds the first job for key 0 until the jobs for key 1 are done
+func (s *HoldingStage) Process(job interface{}) {
+	j := job.(*KeyedJob)
+	switch {
+	case j.ID == 0 && j.Seq == 0:
+		select {
+		case <-s.Release:
+		case <-time.After(2 * time.Second):
+			s.TimedOut = true
+		}
+	case j.ID == 1:
+		if atomic.AddInt32(&s.others, 1) == 5 {
+			close(s.Release)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"sync"
+)
+
+// Pause stops the pipeline from pulling jobs from the generator. Stages keep
+// running and drain the jobs already in the pipeline. Pause may be called
+// before Run to start the pipeline paused.
+func (p *Pipeline) Pause() {
+	if p.config.Logger != nil {
+		p.config.Logger.Println("source=pipeline, action=pause")
+	}
+	p.gate.pause()
+}
+
+// Resume continues pulling jobs from the generator after a Pause
+func (p *Pipeline) Resume() {
+	if p.config.Logger != nil {
+		p.config.Logger.Println("source=pipeline, action=resume")
+	}
+	p.gate.resume()
+}
+
+// Paused reports whether the generator is paused
+func (p *Pipeline) Paused() bool {
+	return p.gate.isPaused()
+}
+
+// PauseStage stops the named stage from starting new jobs. Jobs already in
+// Process are finished and the workers are kept waiting. Upstream stages
+// continue until the queue feeding the paused stage is full. If more than one
+// stage has the name the first is paused.
+func (p *Pipeline) PauseStage(name string) error {
+	r := p.runner(name)
+	if r == nil {
+		return ErrNoStage
+	}
+	if p.config.Logger != nil {
+		p.config.Logger.Printf("source=pipeline, action=pause, stage='%v'\n", name)
+	}
+	r.gate.pause()
+	return nil
+}
+
+// ResumeStage resumes the named stage after a PauseStage
+func (p *Pipeline) ResumeStage(name string) error {
+	r := p.runner(name)
+	if r == nil {
+		return ErrNoStage
+	}
+	if p.config.Logger != nil {
+		p.config.Logger.Printf("source=pipeline, action=resume, stage='%v'\n", name)
+	}
+	r.gate.resume()
+	return nil
+}
+
+func (p *Pipeline) runner(name string) *runner {
+	for _, r := range p.runners {
+		if r.stage.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// resumeAll releases the generator and every stage; used by Abort so a paused
+// pipeline can drain
+func (p *Pipeline) resumeAll() {
+	p.gate.resume()
+	for _, r := range p.runners {
+		r.gate.resume()
+	}
+}
+
+// gate blocks callers of wait while paused; the zero value is open
+type gate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func (g *gate) pause() {
+	g.mu.Lock()
+	g.paused = true
+	g.mu.Unlock()
+}
+
+func (g *gate) resume() {
+	g.mu.Lock()
+	g.paused = false
+	if g.cond != nil {
+		g.cond.Broadcast()
+	}
+	g.mu.Unlock()
+}
+
+func (g *gate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+func (g *gate) wait() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.paused {
+		if g.cond == nil {
+			g.cond = sync.NewCond(&g.mu)
+		}
+		g.cond.Wait()
+	}
+}
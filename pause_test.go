@@ -0,0 +1,133 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+func TestPauseResume(t *testing.T) {
+	p := pipeline.New()
+	generator := &CountsToTenGenerator{}
+	p.SetGenerator(generator)
+
+	stage := &CountingStage{}
+	p.AddStage(stage)
+
+	p.Pause()
+	if !p.Paused() {
+		t.Errorf("expected the pipeline to be paused")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.Run()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if ss := p.Snapshot(); !ss.Paused || ss.Stages[0].Processed != 0 {
+		t.Errorf("expected no jobs while paused, got %+v", ss)
+	}
+
+	p.Resume()
+	if err := <-done; err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if generator.NextCount != 11 || stage.ProcessCount != 10 {
+		t.Errorf("expected all jobs after resuming")
+	}
+}
+
+func TestPauseStage(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	stage := &CountingStage{}
+	p.AddStage(stage)
+
+	if err := p.PauseStage("NoSuchStage"); err != pipeline.ErrNoStage {
+		t.Errorf("expected ErrNoStage")
+	}
+	if err := p.PauseStage("CountingStage"); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.Run()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	ss := p.Snapshot().Stages[0]
+	if !ss.Paused || ss.Processed != 0 || ss.Active != 1 {
+		t.Errorf("expected the stage to be paused with its worker alive, got %+v", ss)
+	}
+
+	// Abort resumes the paused stage so the pipeline drains
+	p.Abort()
+	if err := <-done; err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if stage.ProcessCount != 10 {
+		t.Errorf("expected stage.ProcessCount == 10")
+	}
+
+	if err := p.ResumeStage("CountingStage"); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+}
+
+func TestPauseStageLeavesJobsQueued(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	stage := &CountingStage{}
+	p.AddStage(&FlakyStage{}, stage)
+	p.PauseStage("CountingStage")
+
+	done := make(chan error)
+	go func() {
+		done <- p.Run()
+	}()
+
+	for p.Snapshot().Stages[0].Processed != 10 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// the paused worker has not taken a job off its queue
+	if ss := p.Snapshot().Stages[1]; ss.Queued != 10 || ss.InFlight != 0 {
+		t.Errorf("expected every job to be left in the queue, got %+v", ss)
+	}
+
+	p.ResumeStage("CountingStage")
+	if err := <-done; err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if stage.ProcessCount != 10 {
+		t.Errorf("expected stage.ProcessCount == 10")
+	}
+}
@@ -26,6 +26,8 @@ import (
 	"errors"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ErrNilGenerator is returned when the pipeline has a nil generator.
@@ -36,6 +38,23 @@ var ErrNilGenerator = errors.New("pipeline: the generator cannot be nil")
 // to add one more more stages to the pipeline.
 var ErrNoStages = errors.New("pipeline: there are no stages defined")
 
+// ErrNotRunning is returned when an operation requires the pipeline, or the
+// stage, to be running.
+var ErrNotRunning = errors.New("pipeline: the pipeline is not running")
+
+// ErrNoStage is returned when no stage has the given name.
+var ErrNoStage = errors.New("pipeline: there is no stage with that name")
+
+// ErrConcurrency is returned by Resize when the concurrency is less than 1.
+var ErrConcurrency = errors.New("pipeline: the concurrency must be at least 1")
+
+// ErrTimeout is logged and counted when a job exceeds the stage timeout.
+var ErrTimeout = errors.New("pipeline: the stage timed out processing the job")
+
+// ErrQueueIndex is returned by SetQueue when the index does not refer to a
+// queue in the pipeline.
+var ErrQueueIndex = errors.New("pipeline: the queue index is out of range")
+
 // Generator defines an interface that creates 'jobs' to be processed by the pipeline
 type Generator interface {
 	Name() string
@@ -50,19 +69,90 @@ type Stage interface {
 	Process(interface{})
 }
 
+// Retryable may be implemented by a Stage whose processing can fail. The
+// pipeline calls TryProcess in place of Process and, on error, retries up to
+// StageOptions.Retries times. A job that still fails is counted as an error
+// and passed on to the next stage.
+type Retryable interface {
+	TryProcess(interface{}) error
+}
+
+// Emitter may be implemented by a Stage that does not pass each job on as is,
+// such as one that aggregates, splits or filters jobs. The pipeline calls Emit
+// in place of Process and the jobs passed to emit, rather than job, go to the
+// next stage. An Emitter is neither retried nor bound by a timeout.
+type Emitter interface {
+	Emit(job interface{}, emit func(interface{}))
+}
+
+// Flusher may be implemented by a Stage that holds jobs back. Flush is called
+// once the input of the stage is exhausted and its workers have exited; the
+// jobs passed to emit go to the next stage before its input is closed.
+type Flusher interface {
+	Flush(emit func(interface{}))
+}
+
+// StageOptions overrides or extends the behavior of a stage added with
+// AddStageWithOptions. Concurrency, when greater than 0, replaces the value
+// returned by Stage.Concurrency. Retries and RetryDelay apply to a Retryable
+// stage. Timeout, when greater than 0, bounds the time spent processing a
+// job; a job that times out is counted as an error and dropped, without being
+// acknowledged to an Acknowledger queue, since the stage still holds it. The
+// worker waits for Process to return before taking another job, so a stage
+// never runs more than Concurrency calls at once and Run does not return
+// while one is outstanding; a Process that never returns holds its worker
+// for good. Partitioned gives each worker its own share of the jobs by key,
+// taken from Key when set or else from a job that implements Keyed, so that
+// the jobs for a key are processed one at a time. Middleware wraps the stage;
+// see Middleware. When, if set, selects the jobs the stage processes; the
+// others bypass the stage, without taking one of its workers, and are counted
+// as skipped.
+type StageOptions struct {
+	_           struct{}
+	Concurrency int
+	Retries     int
+	RetryDelay  time.Duration
+	Timeout     time.Duration
+	Partitioned bool
+	Key         func(job interface{}) string
+	Middleware  []Middleware
+	When        func(job interface{}) bool
+}
+
 // Pipeline defines the container for the generator and stages
 type Pipeline struct {
 	_         struct{}
 	generator Generator
 	stages    []Stage
-	channels  []chan interface{}
+	runners   []*runner
+	queues    []Queue
 	config    Config
+	running   int32
+	gate      gate
+	mu        sync.Mutex
+	monitor   *monitor
+	seq       uint64
 }
 
-// Config defines the configuration for a Pipeline
+// Config defines the configuration for a Pipeline. NewQueue, when set, is
+// used in place of NewChanQueue to create the queue at each stage boundary.
+// Clock, when set, replaces the SystemClock for timeouts, retries and the
+// times reported by Snapshot. Seed, when non-zero, seeds the random sources
+// given to Seeded stages and generators. Output, when set, is called with each
+// job that leaves the final stage, in the order they complete; it is called
+// from a single goroutine and holds up the pipeline while it runs. Stream
+// enables the streaming mode. Middleware wraps every stage added to the
+// pipeline. Metadata carries each job through the pipeline in an Envelope.
 type Config struct {
 	_             struct{}
 	Logger        *log.Logger
+	NewQueue      func(capacity int) Queue
+	Output        func(job interface{})
+	Stream        StreamConfig
+	Middleware    []Middleware
+	Metadata      bool
+	Clock         Clock
+	Seed          int64
 	Depth         int
 	Buffered      bool
 	NoConcurrency bool
@@ -91,7 +181,9 @@ func New() *Pipeline {
 	}
 }
 
-// Abort gracefully terminates a Pipeline by calling Abort on the generator
+// Abort gracefully terminates a Pipeline by calling Abort on the generator.
+// A paused generator and any paused stages are resumed so the pipeline can
+// drain.
 func (p *Pipeline) Abort() error {
 
 	if p.generator == nil {
@@ -101,44 +193,66 @@ func (p *Pipeline) Abort() error {
 		return ErrNilGenerator
 	}
 	p.generator.Abort()
+	p.resumeAll()
 	return nil
 }
 
 // Run will pull work from the generator and pass it through the pipeline. This
 // call will block until the pipeline has completed.
 func (p *Pipeline) Run() error {
-
-	if p.generator == nil {
-		if p.config.Logger != nil {
-			p.config.Logger.Println("source=pipeline, error='generator cannot be nil'")
-		}
-		return ErrNilGenerator
+	if err := p.start(); err != nil {
+		return err
 	}
+	p.drain(nil)
+	return nil
+}
 
-	if len(p.stages) == 0 {
-		if p.config.Logger != nil {
-			p.config.Logger.Println("source=pipeline, error='there are no stages defined'")
-		}
-		return ErrNoStages
+// Collect runs the pipeline like Run and returns the jobs that left the final
+// stage, in the order they completed.
+func (p *Pipeline) Collect() ([]interface{}, error) {
+	if err := p.start(); err != nil {
+		return nil, err
 	}
 
-	if p.config.Logger != nil {
-		p.config.Logger.Printf("source=pipeline, notice=config, generator='%v', buffered=%v, concurrency=%v, verbose=%v\n", p.generator.Name(), p.config.Buffered, !p.config.NoConcurrency, p.config.Verbose)
-		for _, s := range p.stages {
-			p.config.Logger.Printf("source=pipeline, notice=config, stage='%v', concurrency=%v\n", s.Name(), p.concurrency(s))
-		}
+	var jobs []interface{}
+	p.drain(func(job interface{}) bool {
+		jobs = append(jobs, job)
+		return true
+	})
+	return jobs, nil
+}
+
+// start validates the pipeline and launches the generator and the stages
+func (p *Pipeline) start() error {
+
+	if err := p.check(); err != nil {
+		return err
 	}
 
 	if p.config.Logger != nil && p.config.Verbose {
 		p.config.Logger.Println("source=pipeline, action=starting")
 	}
 
+	p.inject(p.config.Seed)
+
+	var m *monitor
+	if p.config.Stream.enabled() {
+		m = newMonitor(p, p.config.Stream)
+	}
+	p.mu.Lock()
+	p.monitor = m
+	p.mu.Unlock()
+
 	go func() {
-		defer close(p.channels[0])
+		defer p.queues[0].Close()
 		for {
+			p.gate.wait()
 			job := p.generator.Next()
 			if job != nil {
-				p.channels[0] <- job
+				if m != nil {
+					m.generate(p.clock().Now())
+				}
+				p.send(0, p.envelope(job), nil)
 			} else {
 				if p.config.Logger != nil && p.config.Verbose {
 					p.config.Logger.Println("source=pipeline, action=closing")
@@ -151,101 +265,529 @@ func (p *Pipeline) Run() error {
 	// launch all the stages
 	// read from the previous stage
 	// write to the next
-	for idx, s := range p.stages {
+	for idx, r := range p.runners {
 		if p.config.Logger != nil && p.config.Verbose {
-			p.config.Logger.Printf("source=pipeline, action=launching, stage='%v', concurrency=%v\n", s.Name(), p.concurrency(s))
+			p.config.Logger.Printf("source=pipeline, action=launching, stage='%v', concurrency=%v\n", r.stage.Name(), p.concurrency(r))
 		}
 
-		wg := &sync.WaitGroup{}
+		r.in, r.out = p.queues[idx], p.queues[idx+1]
+		r.emit = func(idx int, r *runner) func(interface{}) {
+			return func(job interface{}) {
+				p.send(idx+1, job, r)
+			}
+		}(idx, r)
+
 		// set cfg.NoConcurrency = true if you want 1 goroutine each; helps w/ debugging
-		for id := 0; id < p.concurrency(s); id++ {
-			wg.Add(1)
-			go stage(p.channels[idx], p.channels[idx+1], id, wg, s, p.config.Logger, p.config.Verbose)
+		r.mu.Lock()
+		r.workers, r.excess, r.nextID, r.drained = 0, 0, 0, false
+		if r.partitioned() {
+			// a busy worker should not hold up the jobs for the other workers
+			depth := p.config.Depth
+			if depth < 1 {
+				depth = 1
+			}
+			r.parts = make([]chan interface{}, p.concurrency(r))
+			for i := range r.parts {
+				r.parts[i] = make(chan interface{}, depth)
+			}
+			go p.dispatch(r)
+		}
+		p.grow(r, p.concurrency(r))
+		r.mu.Unlock()
+
+		// the out queue can only be closed once; and only after all the goroutines have exited
+		go func(r *runner) {
+			if p.config.Logger != nil && p.config.Verbose {
+				p.config.Logger.Printf("source=pipeline, stage='%v', action=wait\n", r.stage.Name())
+			}
+			r.wg.Wait()
+			if _, ok := r.stage.(Flusher); ok {
+				if p.config.Logger != nil && p.config.Verbose {
+					p.config.Logger.Printf("source=pipeline, stage='%v', action=flush\n", r.stage.Name())
+				}
+				p.flush(r)
+			}
+			if p.config.Logger != nil && p.config.Verbose {
+				p.config.Logger.Printf("source=pipeline, stage='%v', action=closing channel\n", r.stage.Name())
+			}
+			r.out.Close()
+		}(r)
+	}
+
+	atomic.StoreInt32(&p.running, 1)
+	if m != nil {
+		go m.run()
+	}
+	return nil
+}
+
+// drain reads the last queue until the pipeline has completed, passing each
+// job to Config.Output and to sink. Once sink returns false the pipeline is
+// aborted and the remaining jobs are not passed to it.
+func (p *Pipeline) drain(sink func(job interface{}) bool) {
+	defer atomic.StoreInt32(&p.running, 0)
+
+	last := p.queues[len(p.queues)-1]
+	for {
+		job, ok := last.Get()
+		if !ok {
+			break
+		}
+		if p.config.Output != nil {
+			p.config.Output(Unwrap(job))
 		}
+		if sink != nil && !sink(Unwrap(job)) {
+			sink = nil
+			p.Abort()
+		}
+		ack(last, job)
 	}
 
-	// drain the last channel
-	for range p.channels[len(p.channels)-1] {
+	p.mu.Lock()
+	m := p.monitor
+	p.mu.Unlock()
+	if m != nil {
+		m.stop()
 	}
 
 	if p.config.Logger != nil && p.config.Verbose {
 		p.config.Logger.Println("source=pipeline, action=terminating")
 	}
+}
+
+// check validates the pipeline and logs its configuration
+func (p *Pipeline) check() error {
+	if p.generator == nil {
+		if p.config.Logger != nil {
+			p.config.Logger.Println("source=pipeline, error='generator cannot be nil'")
+		}
+		return ErrNilGenerator
+	}
+
+	if len(p.stages) == 0 {
+		if p.config.Logger != nil {
+			p.config.Logger.Println("source=pipeline, error='there are no stages defined'")
+		}
+		return ErrNoStages
+	}
 
+	if p.config.Logger != nil {
+		p.config.Logger.Printf("source=pipeline, notice=config, generator='%v', buffered=%v, concurrency=%v, verbose=%v\n", p.generator.Name(), p.config.Buffered, !p.config.NoConcurrency, p.config.Verbose)
+		for _, r := range p.runners {
+			p.config.Logger.Printf("source=pipeline, notice=config, stage='%v', concurrency=%v\n", r.stage.Name(), p.concurrency(r))
+		}
+	}
 	return nil
 }
 
 // SetGenerator sets the generator for the Pipeline
 func (p *Pipeline) SetGenerator(generator Generator) {
-	c := make(chan interface{}, 1)
-	p.channels = append(p.channels, c)
+	p.queues = append(p.queues, p.newQueue(1))
 	p.generator = generator
 }
 
 // AddStage adds 1 or more stages to the pipeline.  Jobs are passed through the
 // stages in the order they are added.
 func (p *Pipeline) AddStage(stages ...Stage) {
+	for _, s := range stages {
+		p.AddStageWithOptions(s, StageOptions{})
+	}
+}
+
+// AddStageWithOptions adds a stage to the pipeline with options that override
+// or extend the behavior defined by the stage itself.
+func (p *Pipeline) AddStageWithOptions(s Stage, opts StageOptions) {
+	if c, ok := s.(*Composite); ok {
+		c.expand(c.name, opts, p.AddStageWithOptions)
+		return
+	}
+
 	// creates the next channel in the list
 	// reads from the upstream channel
 	// writes to the channel created here
 
-	for _, s := range stages {
-		var c chan interface{}
+	s = chain(s, p.config.Middleware, opts.Middleware)
+	r := newRunner(s, opts)
+
+	capacity := 0
+	if p.config.Buffered {
+		capacity = p.concurrency(r) * p.config.Depth
+	}
+
+	p.queues = append(p.queues, p.newQueue(capacity))
+	p.stages = append(p.stages, s)
+	p.runners = append(p.runners, r)
+}
+
+// SetQueue replaces the queue at index i with q. Queue 0 sits between the
+// generator and the first stage, queue i feeds the stage added i-th (from 0)
+// and the last queue holds the output of the final stage. SetQueue must be
+// called after the stages have been added and before Run.
+func (p *Pipeline) SetQueue(i int, q Queue) error {
+	if i < 0 || i >= len(p.queues) {
+		return ErrQueueIndex
+	}
+	p.queues[i] = q
+	return nil
+}
+
+// Resize changes the number of worker goroutines for the named stage while
+// the pipeline is running. If more than one stage has the name the first is
+// resized. Workers being removed finish the job they are processing first.
+func (p *Pipeline) Resize(name string, n int) error {
+	if n < 1 {
+		return ErrConcurrency
+	}
+
+	for _, r := range p.runners {
+		if r.stage.Name() != name {
+			continue
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.partitioned() {
+			return ErrPartitioned
+		}
+		if atomic.LoadInt32(&p.running) == 0 || r.drained {
+			return ErrNotRunning
+		}
+
+		if p.config.Logger != nil {
+			p.config.Logger.Printf("source=pipeline, action=resize, stage='%v', from=%v, to=%v\n", name, r.workers, n)
+		}
 
-		if p.config.Buffered {
-			c = make(chan interface{}, p.concurrency(s)*p.config.Depth)
+		if n > r.workers {
+			p.grow(r, n-r.workers)
 		} else {
-			c = make(chan interface{})
+			r.excess += r.workers - n
+			r.workers = n
 		}
+		return nil
+	}
+	return ErrNoStage
+}
 
-		p.channels = append(p.channels, c)
-		p.stages = append(p.stages, s)
+// grow adds n workers to the stage; r.mu must be held
+func (p *Pipeline) grow(r *runner, n int) {
+	r.workers += n
+
+	// reclaim workers that have been asked to exit but have not yet done so
+	reclaim := n
+	if reclaim > r.excess {
+		reclaim = r.excess
+	}
+	r.excess -= reclaim
+
+	for i := reclaim; i < n; i++ {
+		r.wg.Add(1)
+		go p.stage(r.nextID, r)
+		r.nextID++
 	}
 }
 
-func stage(in chan interface{}, out chan interface{}, id int, wg *sync.WaitGroup, s Stage, logger *log.Logger, verbose bool) {
+func (p *Pipeline) stage(id int, r *runner) {
+	s, in, logger, verbose := r.stage, r.in, p.config.Logger, p.config.Verbose
+	_, emits := s.(Emitter)
 
-	// a channel can only be closed once; let goroutine[0] close it; but only after all the goroutines have exited
-	if id == 0 {
-		defer func() {
-			if logger != nil && verbose {
-				logger.Printf("source=pipeline, stage='%v:%v', action=wait\n", s.Name(), id)
-			}
-			wg.Wait()
-			if logger != nil && verbose {
-				logger.Printf("source=pipeline, stage='%v:%v', action=closing channel\n", s.Name(), id)
-			}
-			close(out)
-		}()
+	get := in.Get
+	if r.parts != nil {
+		part := r.parts[id]
+		get = func() (interface{}, bool) {
+			job, ok := <-part
+			return job, ok
+		}
 	}
 
 	// defer the waitgroup notification
+	atomic.AddInt32(&r.active, 1)
 	defer func() {
 		if logger != nil && verbose {
 			logger.Printf("source=pipeline, stage='%v:%v', action=done\n", s.Name(), id)
 		}
-		wg.Done()
+		atomic.AddInt32(&r.active, -1)
+		r.wg.Done()
 	}()
 
 	if logger != nil {
 		logger.Printf("source=pipeline, stage='%v:%v', action=ready\n", s.Name(), id)
 	}
 
-	for job := range in {
+	for {
+		// a paused stage leaves its jobs in the queue until it is resumed
+		r.gate.wait()
+
+		if r.retire() {
+			return
+		}
+
+		job, ok := get()
+		if !ok {
+			r.mu.Lock()
+			r.drained = true
+			r.mu.Unlock()
+			break
+		}
+
 		if logger != nil && verbose {
 			logger.Printf("source=pipeline, stage='%v:%v', action=processing\n", s.Name(), id)
 		}
 
-		s.Process(job)
+		start := p.clock().Now()
+		r.begin(id, start)
+		ok = p.process(r, job)
+		r.end(id)
+		p.record(r, job, start)
+
+		// a job that timed out is dropped
+		if !ok {
+			continue
+		}
+
+		// send it to the next stage; only then is it safe to release it upstream
+		if !emits {
+			r.emit(job)
+		}
+		ack(in, job)
+	}
+}
+
+// process runs the job through the stage, retrying a Retryable stage and
+// enforcing the timeout. It returns false when the job timed out; Process may
+// have left the job half done, so it is neither passed on nor acknowledged.
+func (p *Pipeline) process(r *runner, job interface{}) bool {
+	if _, ok := r.stage.(Emitter); ok || r.opts.Timeout <= 0 {
+		if err := p.try(r, job); err != nil {
+			p.fail(r, err)
+		}
+		return true
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.try(r, job)
+	}()
+
+	timer := p.clock().NewTimer(r.opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			p.fail(r, err)
+		}
+		return true
+	case <-timer.C():
+		p.fail(r, ErrTimeout)
+		// the worker is not free until Process returns
+		<-done
+		return false
+	}
+}
+
+func (p *Pipeline) try(r *runner, job interface{}) error {
+	env, _ := job.(*Envelope)
+	if env != nil {
+		job = env.Job
+	}
+
+	if e, ok := r.stage.(Emitter); ok {
+		emit := r.emit
+		if env != nil {
+			emit = func(job interface{}) {
+				r.emit(env.derive(job))
+			}
+		}
+		if te, ok := e.(tryEmitter); ok {
+			return te.tryEmit(job, emit)
+		}
+		e.Emit(job, emit)
+		return nil
+	}
+
+	var once func() error
+	if ep, ok := r.stage.(EnvelopeProcessor); ok && env != nil {
+		once = func() error {
+			return ep.ProcessEnvelope(env)
+		}
+	} else if t, ok := r.stage.(Retryable); ok {
+		once = func() error {
+			return t.TryProcess(job)
+		}
+	} else {
+		r.stage.Process(job)
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt <= r.opts.Retries; attempt++ {
+		if attempt > 0 {
+			if p.config.Logger != nil && p.config.Verbose {
+				p.config.Logger.Printf("source=pipeline, stage='%v', action=retry, attempt=%v, error='%v'\n", r.stage.Name(), attempt, err)
+			}
+			p.clock().Sleep(r.opts.RetryDelay)
+		}
+		if err = once(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// flush flushes a Flusher stage, passing the jobs it emits downstream
+func (p *Pipeline) flush(r *runner) {
+	emit := func(job interface{}) {
+		r.emit(p.envelope(job))
+	}
+
+	var err error
+	if f, ok := r.stage.(tryFlusher); ok {
+		err = f.tryFlush(emit)
+	} else if f, ok := r.stage.(Flusher); ok {
+		f.Flush(emit)
+	}
+	if err != nil {
+		p.fail(r, err)
+	}
+}
+
+// record adds the time the stage spent on the job to its Envelope
+func (p *Pipeline) record(r *runner, job interface{}, start time.Time) {
+	if env, ok := job.(*Envelope); ok {
+		env.record(StageTiming{Stage: r.stage.Name(), Start: start, End: p.clock().Now()})
+	}
+}
+
+func (p *Pipeline) fail(r *runner, err error) {
+	atomic.AddUint64(&r.errors, 1)
+	if p.config.Logger != nil {
+		p.config.Logger.Printf("source=pipeline, stage='%v', error='%v'\n", r.stage.Name(), err)
+	}
+}
+
+// runner holds the runtime state of a stage
+type runner struct {
+	_         struct{}
+	stage     Stage
+	opts      StageOptions
+	in, out   Queue
+	emit      func(interface{}) // passes a job emitted by the stage downstream
+	wg        sync.WaitGroup
+	active    int32
+	blocked   int32 // workers waiting for room in a downstream queue
+	errors    uint64
+	gate      gate
+	mu        sync.Mutex
+	workers   int  // the configured number of workers
+	excess    int  // workers asked to exit by Resize
+	nextID    int  // id of the next worker launched
+	drained   bool // the input queue is closed and empty
+	processed uint64
+	skipped   uint64
+	rejected  uint64             // jobs the input queue refused
+	inflight  map[int]time.Time  // worker id to the time it started the job
+	parts     []chan interface{} // the input of each worker of a partitioned stage
+	next      int                // the worker for the next job without a key
+}
+
+func newRunner(s Stage, opts StageOptions) *runner {
+	return &runner{
+		stage:    s,
+		opts:     opts,
+		inflight: make(map[int]time.Time),
+	}
+}
+
+// retire reports whether the calling worker should exit to honor a Resize
+func (r *runner) retire() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.excess > 0 {
+		r.excess--
+		return true
+	}
+	return false
+}
+
+func (r *runner) begin(id int, now time.Time) {
+	r.mu.Lock()
+	r.inflight[id] = now
+	r.mu.Unlock()
+}
+
+func (r *runner) end(id int) {
+	r.mu.Lock()
+	delete(r.inflight, id)
+	r.processed++
+	r.mu.Unlock()
+}
+
+// put puts the job in queue i. A job the queue refuses, such as one its codec
+// cannot encode, is lost: it is counted as rejected by the queue and as an
+// error of the stage that sent it, or logged when the generator sent it.
+func (p *Pipeline) put(i int, job interface{}, from *runner) {
+	if from != nil {
+		atomic.AddInt32(&from.blocked, 1)
+	}
+	err := p.queues[i].Put(job)
+	if from != nil {
+		atomic.AddInt32(&from.blocked, -1)
+	}
+	if err == nil {
+		return
+	}
+	if i < len(p.runners) {
+		atomic.AddUint64(&p.runners[i].rejected, 1)
+	}
+	if from != nil {
+		p.fail(from, err)
+	} else if p.config.Logger != nil {
+		p.config.Logger.Printf("source=pipeline, generator='%v', error='%v'\n", p.generator.Name(), err)
+	}
+}
+
+func (p *Pipeline) clock() Clock {
+	if p.config.Clock != nil {
+		return p.config.Clock
+	}
+	return SystemClock{}
+}
+
+// inject hands the clock, and a random source derived from a non-zero seed, to
+// the generator, stages and queues that accept them
+func (p *Pipeline) inject(seed int64) {
+	targets := []interface{}{p.generator}
+	for _, r := range p.runners {
+		targets = append(targets, r.stage)
+	}
+	for _, q := range p.queues {
+		targets = append(targets, q)
+	}
+
+	for i, t := range targets {
+		if c, ok := t.(Clocked); ok {
+			c.SetClock(p.clock())
+		}
+		if s, ok := t.(Seeded); ok && seed != 0 {
+			s.SetRand(newRand(seed + int64(i)))
+		}
+	}
+}
 
-		// send it to the next stage
-		out <- job
+func (p *Pipeline) newQueue(capacity int) Queue {
+	if p.config.NewQueue != nil {
+		return p.config.NewQueue(capacity)
 	}
+	return NewChanQueue(capacity)
 }
 
-func (p *Pipeline) concurrency(s Stage) int {
+func (p *Pipeline) concurrency(r *runner) int {
 	if p.config.NoConcurrency {
 		return 1
 	}
-	return s.Concurrency()
+	if r.opts.Concurrency > 0 {
+		return r.opts.Concurrency
+	}
+	return r.stage.Concurrency()
 }
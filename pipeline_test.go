@@ -29,6 +29,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/jboelter/pipeline"
 )
@@ -302,6 +303,244 @@ func TestOneStageWithLoggerNoBuffer(t *testing.T) {
 	}
 }
 
+func TestSetQueue(t *testing.T) {
+	p := pipeline.New()
+	generator := &CountsToTenGenerator{}
+	p.SetGenerator(generator)
+
+	stage := &CountingStage{}
+	p.AddStage(stage)
+
+	if err := p.SetQueue(2, &AckingQueue{}); err != pipeline.ErrQueueIndex {
+		t.Errorf("expected ErrQueueIndex")
+	}
+
+	q := &AckingQueue{c: make(chan interface{}, 1)}
+	if err := p.SetQueue(1, q); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	err := p.Run()
+	if err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	if stage.ProcessCount != 10 {
+		t.Errorf("expected stage.ProcessCount == 10")
+	}
+
+	if q.AckCount != 10 {
+		t.Errorf("expected q.AckCount == 10")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	stage := &BlockingStage{Started: make(chan struct{}, 10), Release: make(chan struct{})}
+	p.AddStage(stage)
+
+	done := make(chan error)
+	go func() {
+		done <- p.Run()
+	}()
+
+	// wait for every worker to pick up a job
+	for i := 0; i < stage.Concurrency(); i++ {
+		<-stage.Started
+	}
+	time.Sleep(time.Millisecond)
+
+	snap := p.Snapshot()
+	if !snap.Running || snap.Generator != "CountsToTenGenerator" || len(snap.Stages) != 1 {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+
+	ss := snap.Stages[0]
+	if ss.Name != "BlockingStage" || ss.Workers != 2 || ss.Active != 2 || ss.InFlight != 2 || ss.Capacity != 1 {
+		t.Errorf("unexpected stage snapshot %+v", ss)
+	}
+	if ss.OldestInFlight <= 0 {
+		t.Errorf("expected OldestInFlight > 0")
+	}
+
+	close(stage.Release)
+	if err := <-done; err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	snap = p.Snapshot()
+	ss = snap.Stages[0]
+	if snap.Running || ss.Processed != 10 || ss.Active != 0 || ss.InFlight != 0 {
+		t.Errorf("unexpected final snapshot %+v", snap)
+	}
+}
+
+func TestResize(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	stage := &BlockingStage{Started: make(chan struct{}, 10), Release: make(chan struct{})}
+	p.AddStage(stage)
+
+	if err := p.Resize("BlockingStage", 4); err != pipeline.ErrNotRunning {
+		t.Errorf("expected ErrNotRunning before Run")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.Run()
+	}()
+	for !p.Snapshot().Running {
+	}
+
+	if err := p.Resize("NoSuchStage", 4); err != pipeline.ErrNoStage {
+		t.Errorf("expected ErrNoStage")
+	}
+	if err := p.Resize("BlockingStage", 0); err != pipeline.ErrConcurrency {
+		t.Errorf("expected ErrConcurrency")
+	}
+
+	if err := p.Resize("BlockingStage", 4); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	for i := 0; i < 4; i++ {
+		<-stage.Started
+	}
+	if ss := p.Snapshot().Stages[0]; ss.Workers != 4 || ss.Active != 4 {
+		t.Errorf("expected 4 workers, got %+v", ss)
+	}
+
+	if err := p.Resize("BlockingStage", 1); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	close(stage.Release)
+	if err := <-done; err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	if ss := p.Snapshot().Stages[0]; ss.Workers != 1 || ss.Processed != 10 {
+		t.Errorf("unexpected stage snapshot %+v", ss)
+	}
+}
+
+func TestStageOptions(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	flaky := &FlakyStage{Failures: 2}
+	slow := &SlowStage{}
+	counting := &CountingStage{}
+	p.AddStageWithOptions(flaky, pipeline.StageOptions{Retries: 2, Concurrency: 3})
+	p.AddStageWithOptions(slow, pipeline.StageOptions{Timeout: 10 * time.Millisecond})
+	p.AddStage(counting)
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	snap := p.Snapshot()
+	if snap.Stages[0].Workers != 3 {
+		t.Errorf("expected the concurrency to be overridden, got %v", snap.Stages[0].Workers)
+	}
+
+	// each job fails twice then succeeds on the last retry
+	if flaky.Attempts() != 30 || snap.Stages[0].Errors != 0 {
+		t.Errorf("expected 30 attempts and no errors; attempts=%v, errors=%v", flaky.Attempts(), snap.Stages[0].Errors)
+	}
+
+	// job 5 times out and is dropped
+	if snap.Stages[1].Errors != 1 || counting.ProcessCount != 9 {
+		t.Errorf("expected 1 timeout and 9 jobs downstream; errors=%v, processed=%v", snap.Stages[1].Errors, counting.ProcessCount)
+	}
+}
+
+func TestTimeoutWaitsForProcess(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	slow := &SlowKeyedStage{}
+	p.AddStageWithOptions(slow, pipeline.StageOptions{Timeout: 5 * time.Millisecond})
+
+	q := &AckingQueue{c: make(chan interface{}, 1)}
+	if err := p.SetQueue(0, q); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	// every job times out, but the single worker waits each one out
+	if slow.most != 1 || slow.active != 0 {
+		t.Errorf("expected one call at a time and none left running; most=%v, active=%v", slow.most, slow.active)
+	}
+	if errs := p.Snapshot().Stages[0].Errors; errs != 10 {
+		t.Errorf("expected 10 timeouts, got %v", errs)
+	}
+	if q.AckCount != 0 {
+		t.Errorf("expected the timed out jobs not to be acknowledged, got %v", q.AckCount)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	flaky := &FlakyStage{Failures: 5}
+	counting := &CountingStage{}
+	p.AddStageWithOptions(flaky, pipeline.StageOptions{Retries: 1})
+	p.AddStage(counting)
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	// failed jobs are still passed on
+	if errs := p.Snapshot().Stages[0].Errors; errs != 10 || counting.ProcessCount != 10 {
+		t.Errorf("expected 10 errors and 10 jobs downstream; errors=%v, processed=%v", errs, counting.ProcessCount)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	var jobs []interface{}
+	cfg := pipeline.DefaultConfig()
+	cfg.NoConcurrency = true
+	cfg.Output = func(job interface{}) {
+		jobs = append(jobs, job)
+	}
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if len(jobs) != 10 || jobs[0] != 1 || jobs[9] != 10 {
+		t.Errorf("expected jobs 1 to 10 in order, got %v", jobs)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{})
+
+	jobs, err := p.Collect()
+	if err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if len(jobs) != 10 {
+		t.Errorf("expected 10 jobs, got %v", jobs)
+	}
+
+	if _, err := pipeline.New().Collect(); err != pipeline.ErrNilGenerator {
+		t.Errorf(`the pipeline generator should be nil`)
+	}
+}
+
 /* test generator */
 type EmptyGenerator struct {
 	NextCount  int
@@ -404,3 +643,111 @@ func (s *ConcurrentStage) Process(interface{}) {
 	s.Waiter.Wait() // wait for everybody; assumes all goroutines got a job
 	atomic.AddInt32(&s.ProcessCount, 1)
 }
+
+/* test queue */
+type AckingQueue struct {
+	c        chan interface{}
+	AckCount int
+}
+
+func (q *AckingQueue) Put(job interface{}) error {
+	q.c <- job
+	return nil
+}
+
+func (q *AckingQueue) Get() (interface{}, bool) {
+	job, ok := <-q.c
+	return job, ok
+}
+
+func (q *AckingQueue) Close() {
+	close(q.c)
+}
+
+func (q *AckingQueue) Len() int {
+	return len(q.c)
+}
+
+func (q *AckingQueue) Ack(interface{}) {
+	q.AckCount++
+}
+
+/* test stage */
+type BlockingStage struct {
+	Started chan struct{}
+	Release chan struct{}
+}
+
+func (s *BlockingStage) Name() string {
+	return "BlockingStage"
+}
+
+func (s *BlockingStage) Concurrency() int {
+	return 2
+}
+
+func (s *BlockingStage) Process(interface{}) {
+	select {
+	case s.Started <- struct{}{}:
+	default:
+	}
+	<-s.Release
+}
+
+/* test stage */
+type FlakyStage struct {
+	Failures int
+	mu       sync.Mutex
+	attempts map[interface{}]int
+}
+
+func (s *FlakyStage) Name() string {
+	return "FlakyStage"
+}
+
+func (s *FlakyStage) Concurrency() int {
+	return 1
+}
+
+func (s *FlakyStage) Process(interface{}) {
+}
+
+func (s *FlakyStage) TryProcess(job interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attempts == nil {
+		s.attempts = make(map[interface{}]int)
+	}
+	s.attempts[job]++
+	if s.attempts[job] <= s.Failures {
+		return fmt.Errorf("attempt %v failed", s.attempts[job])
+	}
+	return nil
+}
+
+func (s *FlakyStage) Attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, a := range s.attempts {
+		n += a
+	}
+	return n
+}
+
+/* test stage */
+type SlowStage struct{}
+
+func (s *SlowStage) Name() string {
+	return "SlowStage"
+}
+
+func (s *SlowStage) Concurrency() int {
+	return 1
+}
+
+func (s *SlowStage) Process(job interface{}) {
+	if job == 5 {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,286 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package pipelinetest provides helpers for unit testing stages and
+// generators.
+//
+//	func TestHash(t *testing.T) {
+//		defer pipelinetest.CheckLeaks(t)()
+//
+//		result, err := pipelinetest.RunStage(hash.Stage, jobs, 4)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		pipelinetest.AssertSameJobs(t, result.Jobs, jobs)
+//		pipelinetest.AssertErrors(t, result.Stage, 0)
+//	}
+package pipelinetest
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+// SliceGenerator is a Generator that returns the jobs of a slice in order
+type SliceGenerator struct {
+	_       struct{}
+	mu      sync.Mutex
+	jobs    []interface{}
+	next    int
+	calls   int
+	aborted bool
+}
+
+// NewSliceGenerator creates a SliceGenerator for the jobs
+func NewSliceGenerator(jobs ...interface{}) *SliceGenerator {
+	return &SliceGenerator{jobs: jobs}
+}
+
+// Name implements pipeline.Generator
+func (g *SliceGenerator) Name() string {
+	return "SliceGenerator"
+}
+
+// Next implements pipeline.Generator
+func (g *SliceGenerator) Next() interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls++
+	if g.aborted || g.next >= len(g.jobs) {
+		return nil
+	}
+	job := g.jobs[g.next]
+	g.next++
+	return job
+}
+
+// Abort implements pipeline.Generator
+func (g *SliceGenerator) Abort() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.aborted = true
+}
+
+// Calls returns the number of times Next was called
+func (g *SliceGenerator) Calls() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+// Aborted reports whether Abort was called
+func (g *SliceGenerator) Aborted() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.aborted
+}
+
+// Recorder is a Stage that records every job it processes, in order. Add it
+// as the last stage to capture the output of a pipeline.
+type Recorder struct {
+	_    struct{}
+	mu   sync.Mutex
+	jobs []interface{}
+}
+
+// Name implements pipeline.Stage
+func (r *Recorder) Name() string {
+	return "Recorder"
+}
+
+// Concurrency implements pipeline.Stage; a single worker keeps the order
+func (r *Recorder) Concurrency() int {
+	return 1
+}
+
+// Process implements pipeline.Stage
+func (r *Recorder) Process(job interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs = append(r.jobs, job)
+}
+
+// Jobs returns a copy of the jobs recorded so far
+func (r *Recorder) Jobs() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]interface{}(nil), r.jobs...)
+}
+
+// Result holds the outcome of RunStage
+type Result struct {
+	_ struct{}
+	// Jobs holds the jobs that came out of the stage, in order
+	Jobs []interface{}
+	// Stage is the final snapshot of the stage under test
+	Stage pipeline.StageSnapshot
+}
+
+// RunStage runs the jobs through the stage with the given concurrency and
+// records what comes out. A concurrency of 0 uses Stage.Concurrency.
+func RunStage(s pipeline.Stage, jobs []interface{}, concurrency int) (Result, error) {
+	p := pipeline.New()
+	p.SetGenerator(NewSliceGenerator(jobs...))
+
+	recorder := &Recorder{}
+	p.AddStageWithOptions(s, pipeline.StageOptions{Concurrency: concurrency})
+	p.AddStage(recorder)
+
+	if err := p.Run(); err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Jobs:  recorder.Jobs(),
+		Stage: p.Snapshot().Stages[0],
+	}, nil
+}
+
+// AssertOrder fails the test unless got holds the same jobs as want, in the
+// same order
+func AssertOrder(t testing.TB, got, want []interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pipelinetest: expected jobs %v, got %v", keys(want), keys(got))
+	}
+}
+
+// AssertSameJobs fails the test unless got holds the same jobs as want in any
+// order
+func AssertSameJobs(t testing.TB, got, want []interface{}) {
+	t.Helper()
+	g, w := sorted(got), sorted(want)
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("pipelinetest: expected jobs %v in any order, got %v", keys(w), keys(g))
+	}
+}
+
+// AssertDropped fails the test unless the stage dropped n jobs from its queue
+func AssertDropped(t testing.TB, s pipeline.StageSnapshot, n uint64) {
+	t.Helper()
+	if s.Dropped != n {
+		t.Errorf("pipelinetest: expected %v dropped jobs for stage '%v', got %v", n, s.Name, s.Dropped)
+	}
+}
+
+// AssertErrors fails the test unless the stage reported n errors
+func AssertErrors(t testing.TB, s pipeline.StageSnapshot, n uint64) {
+	t.Helper()
+	if s.Errors != n {
+		t.Errorf("pipelinetest: expected %v errors for stage '%v', got %v", n, s.Name, s.Errors)
+	}
+}
+
+// sorted orders the jobs by their printed value, following pointers
+func sorted(jobs []interface{}) []interface{} {
+	s := append([]interface{}(nil), jobs...)
+	sort.SliceStable(s, func(i, j int) bool {
+		return key(s[i]) < key(s[j])
+	})
+	return s
+}
+
+func keys(jobs []interface{}) []string {
+	k := make([]string, len(jobs))
+	for i, job := range jobs {
+		k[i] = key(job)
+	}
+	return k
+}
+
+func key(job interface{}) string {
+	v := reflect.ValueOf(job)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%#v", v.Interface())
+}
+
+// LeakTimeout is how long the function returned by CheckLeaks waits for
+// goroutines to exit
+var LeakTimeout = time.Second
+
+// CheckLeaks records the running goroutines and returns a function that fails
+// the test if any new goroutines are still running, after waiting up to
+// LeakTimeout for them to exit. Call it before building the pipeline and
+// defer the result.
+func CheckLeaks(t testing.TB) func() {
+	before := goroutines()
+	return func() {
+		t.Helper()
+
+		var leaked []string
+		deadline := time.Now().Add(LeakTimeout)
+		for {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if len(leaked) > 0 {
+			t.Errorf("pipelinetest: %v goroutines leaked:\n\n%v", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	}
+}
+
+// goroutines returns the stack of every goroutine keyed by its id
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[string]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		s := string(stack)
+		// "goroutine 12 [running]:"
+		fields := strings.Fields(s)
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		stacks[fields[1]] = s
+	}
+	return stacks
+}
@@ -0,0 +1,148 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipelinetest_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/pipelinetest"
+)
+
+type double struct{}
+
+func (s *double) Name() string {
+	return "double"
+}
+
+func (s *double) Concurrency() int {
+	return 1
+}
+
+func (s *double) Process(job interface{}) {
+	*job.(*int) *= 2
+}
+
+func ints(n ...int) []interface{} {
+	jobs := make([]interface{}, len(n))
+	for i := range n {
+		jobs[i] = &n[i]
+	}
+	return jobs
+}
+
+func TestRunStage(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	jobs := ints(1, 2, 3, 4)
+	result, err := pipelinetest.RunStage(&double{}, jobs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipelinetest.AssertOrder(t, result.Jobs, ints(2, 4, 6, 8))
+	pipelinetest.AssertErrors(t, result.Stage, 0)
+	pipelinetest.AssertDropped(t, result.Stage, 0)
+	if result.Stage.Processed != 4 {
+		t.Errorf("expected 4 jobs processed, got %v", result.Stage.Processed)
+	}
+}
+
+func TestRunStageConcurrent(t *testing.T) {
+	defer pipelinetest.CheckLeaks(t)()
+
+	result, err := pipelinetest.RunStage(&double{}, ints(1, 2, 3, 4, 5, 6), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipelinetest.AssertSameJobs(t, result.Jobs, ints(12, 10, 8, 6, 4, 2))
+	if result.Stage.Workers != 3 {
+		t.Errorf("expected 3 workers, got %v", result.Stage.Workers)
+	}
+}
+
+func TestSliceGenerator(t *testing.T) {
+	g := pipelinetest.NewSliceGenerator(1, 2, 3)
+	recorder := &pipelinetest.Recorder{}
+
+	p := pipeline.New()
+	p.SetGenerator(g)
+	p.AddStage(recorder)
+	p.Run()
+
+	pipelinetest.AssertOrder(t, recorder.Jobs(), []interface{}{1, 2, 3})
+	if g.Calls() != 4 || g.Aborted() {
+		t.Errorf("expected 4 calls and no abort; calls=%v, aborted=%v", g.Calls(), g.Aborted())
+	}
+
+	g.Abort()
+	if g.Next() != nil || !g.Aborted() {
+		t.Errorf("expected no jobs once aborted")
+	}
+}
+
+// recorder captures the failures reported by the assertions
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, format)
+}
+
+func TestAssertionsFail(t *testing.T) {
+	r := &recorder{TB: t}
+
+	pipelinetest.AssertOrder(r, []interface{}{1, 2}, []interface{}{2, 1})
+	pipelinetest.AssertSameJobs(r, []interface{}{1, 2}, []interface{}{1, 3})
+	pipelinetest.AssertErrors(r, pipeline.StageSnapshot{Errors: 1}, 0)
+	pipelinetest.AssertDropped(r, pipeline.StageSnapshot{Dropped: 1}, 0)
+	if len(r.errors) != 4 {
+		t.Errorf("expected 4 failures, got %v", len(r.errors))
+	}
+}
+
+func TestCheckLeaks(t *testing.T) {
+	r := &recorder{TB: t}
+	pipelinetest.LeakTimeout = 0
+	defer func() { pipelinetest.LeakTimeout = time.Second }()
+
+	check := pipelinetest.CheckLeaks(r)
+	quit := make(chan struct{})
+	go func() {
+		<-quit
+	}()
+	check()
+	close(quit)
+
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "leaked") {
+		t.Errorf("expected the goroutine to be reported, got %v", r.errors)
+	}
+}
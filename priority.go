@@ -0,0 +1,180 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Prioritized may be implemented by a job to be dispatched ahead of lower
+// priority jobs by a PriorityQueue. Higher values are dispatched first; jobs
+// that do not implement Prioritized have a priority of 0.
+type Prioritized interface {
+	Priority() int
+}
+
+// PriorityQueue is a Queue that always hands out the highest priority job
+// next. To keep low priority jobs from starving, a waiting job gains one
+// level of priority for every aging interval it has spent in the queue.
+// Jobs of equal effective priority are handed out in FIFO order.
+type PriorityQueue struct {
+	_        struct{}
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    priorityHeap
+	capacity int
+	aging    time.Duration
+	clock    Clock
+	start    time.Time
+	seq      uint64
+	closed   bool
+}
+
+type priorityItem struct {
+	job interface{}
+	key float64
+	seq uint64
+}
+
+// NewPriorityQueue creates a PriorityQueue. Put blocks once capacity jobs are
+// waiting; a capacity of 0 or less is unbounded. An aging of 0 disables
+// starvation protection.
+func NewPriorityQueue(capacity int, aging time.Duration) *PriorityQueue {
+	q := &PriorityQueue{
+		capacity: capacity,
+		aging:    aging,
+		clock:    SystemClock{},
+		start:    time.Now(),
+	}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// SetClock implements Clocked; the pipeline sets the clock before Run. Jobs
+// already waiting keep the age they had under the previous clock.
+func (q *PriorityQueue) SetClock(c Clock) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	elapsed := q.clock.Now().Sub(q.start)
+	q.clock = c
+	q.start = c.Now().Add(-elapsed)
+}
+
+// Put implements Queue
+func (q *PriorityQueue) Put(job interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.capacity > 0 && len(q.items) >= q.capacity {
+		q.notFull.Wait()
+	}
+
+	priority := 0
+	if p, ok := Unwrap(job).(Prioritized); ok {
+		priority = p.Priority()
+	}
+
+	// the effective priority at time t is priority + (t-enqueued)/aging; every
+	// waiting job ages at the same rate so ordering by priority - enqueued/aging
+	// is stable and the heap never needs to be rebuilt
+	key := float64(priority)
+	if q.aging > 0 {
+		key -= float64(q.clock.Now().Sub(q.start)) / float64(q.aging)
+	}
+
+	heap.Push(&q.items, &priorityItem{job: job, key: key, seq: q.seq})
+	q.seq++
+	q.notEmpty.Signal()
+	return nil
+}
+
+// Get implements Queue
+func (q *PriorityQueue) Get() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	item := heap.Pop(&q.items).(*priorityItem)
+	q.notFull.Signal()
+	return item.job, true
+}
+
+// Close implements Queue
+func (q *PriorityQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+}
+
+// Len implements Queue
+func (q *PriorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Cap returns the capacity of the queue, or -1 when it is unbounded
+func (q *PriorityQueue) Cap() int {
+	if q.capacity <= 0 {
+		return -1
+	}
+	return q.capacity
+}
+
+type priorityHeap []*priorityItem
+
+func (h priorityHeap) Len() int {
+	return len(h)
+}
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key > h[j].key
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h priorityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *priorityHeap) Push(x interface{}) {
+	*h = append(*h, x.(*priorityItem))
+}
+
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
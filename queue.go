@@ -0,0 +1,246 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Queue defines the hand-off between the generator and a stage or between two
+// stages. Put may block when the queue is full. Get blocks until a job is
+// available and returns false once the queue is closed and empty. Close is
+// called exactly once by the upstream side when no more jobs will be Put.
+// Len reports the number of jobs waiting to be taken by Get.
+type Queue interface {
+	Put(job interface{}) error
+	Get() (interface{}, bool)
+	Close()
+	Len() int
+}
+
+// Acknowledger may be implemented by a Queue that needs to know when a job it
+// handed out is no longer needed. The pipeline calls Ack once the consuming
+// stage has processed the job and passed it to the next queue; a job that
+// timed out is not acknowledged.
+type Acknowledger interface {
+	Ack(job interface{})
+}
+
+// ChanQueue is a Queue backed by a channel. It is the default Queue used by
+// the pipeline.
+type ChanQueue chan interface{}
+
+// NewChanQueue creates a ChanQueue; a capacity of 0 is unbuffered
+func NewChanQueue(capacity int) Queue {
+	return ChanQueue(make(chan interface{}, capacity))
+}
+
+// Put implements Queue
+func (c ChanQueue) Put(job interface{}) error {
+	c <- job
+	return nil
+}
+
+// Get implements Queue
+func (c ChanQueue) Get() (interface{}, bool) {
+	job, ok := <-c
+	return job, ok
+}
+
+// Close implements Queue
+func (c ChanQueue) Close() {
+	close(c)
+}
+
+// Len implements Queue
+func (c ChanQueue) Len() int {
+	return len(c)
+}
+
+// Cap returns the capacity of the channel
+func (c ChanQueue) Cap() int {
+	return cap(c)
+}
+
+// RingQueue is a bounded Queue backed by a ring buffer. Put blocks while the
+// buffer is full.
+type RingQueue struct {
+	_        struct{}
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	buf      []interface{}
+	head     int
+	n        int
+	closed   bool
+}
+
+// NewRingQueue creates a RingQueue holding up to capacity jobs; the capacity
+// is at least 1
+func NewRingQueue(capacity int) *RingQueue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	q := &RingQueue{buf: make([]interface{}, capacity)}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// Put implements Queue
+func (q *RingQueue) Put(job interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.n == len(q.buf) {
+		q.notFull.Wait()
+	}
+	q.buf[(q.head+q.n)%len(q.buf)] = job
+	q.n++
+	q.notEmpty.Signal()
+	return nil
+}
+
+// Get implements Queue
+func (q *RingQueue) Get() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.n == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if q.n == 0 {
+		return nil, false
+	}
+	job := q.buf[q.head]
+	q.buf[q.head] = nil
+	q.head = (q.head + 1) % len(q.buf)
+	q.n--
+	q.notFull.Signal()
+	return job, true
+}
+
+// Close implements Queue
+func (q *RingQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+}
+
+// Len implements Queue
+func (q *RingQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// Cap returns the capacity of the ring buffer
+func (q *RingQueue) Cap() int {
+	return len(q.buf)
+}
+
+// QueueStats holds the counters collected by an InstrumentedQueue
+type QueueStats struct {
+	Puts    uint64
+	Gets    uint64
+	MaxLen  int
+	PutWait time.Duration // total time spent blocked in Put
+	GetWait time.Duration // total time spent blocked in Get
+}
+
+// InstrumentedQueue wraps a Queue and records how it is used
+type InstrumentedQueue struct {
+	_       struct{}
+	q       Queue
+	puts    uint64
+	gets    uint64
+	maxLen  int64
+	putWait int64
+	getWait int64
+}
+
+// Instrument wraps q in an InstrumentedQueue
+func Instrument(q Queue) *InstrumentedQueue {
+	return &InstrumentedQueue{q: q}
+}
+
+// Put implements Queue
+func (i *InstrumentedQueue) Put(job interface{}) error {
+	start := time.Now()
+	err := i.q.Put(job)
+	atomic.AddInt64(&i.putWait, int64(time.Since(start)))
+	atomic.AddUint64(&i.puts, 1)
+
+	n := int64(i.q.Len())
+	for {
+		max := atomic.LoadInt64(&i.maxLen)
+		if n <= max || atomic.CompareAndSwapInt64(&i.maxLen, max, n) {
+			break
+		}
+	}
+	return err
+}
+
+// Get implements Queue
+func (i *InstrumentedQueue) Get() (interface{}, bool) {
+	start := time.Now()
+	job, ok := i.q.Get()
+	atomic.AddInt64(&i.getWait, int64(time.Since(start)))
+	if ok {
+		atomic.AddUint64(&i.gets, 1)
+	}
+	return job, ok
+}
+
+// Close implements Queue
+func (i *InstrumentedQueue) Close() {
+	i.q.Close()
+}
+
+// Len implements Queue
+func (i *InstrumentedQueue) Len() int {
+	return i.q.Len()
+}
+
+// Ack passes the acknowledgment to the wrapped Queue if it is an Acknowledger
+func (i *InstrumentedQueue) Ack(job interface{}) {
+	ack(i.q, job)
+}
+
+// Stats returns a copy of the counters
+func (i *InstrumentedQueue) Stats() QueueStats {
+	return QueueStats{
+		Puts:    atomic.LoadUint64(&i.puts),
+		Gets:    atomic.LoadUint64(&i.gets),
+		MaxLen:  int(atomic.LoadInt64(&i.maxLen)),
+		PutWait: time.Duration(atomic.LoadInt64(&i.putWait)),
+		GetWait: time.Duration(atomic.LoadInt64(&i.getWait)),
+	}
+}
+
+func ack(q Queue, job interface{}) {
+	if a, ok := q.(Acknowledger); ok {
+		a.Ack(job)
+	}
+}
@@ -0,0 +1,306 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+func TestRingQueue(t *testing.T) {
+	q := pipeline.NewRingQueue(3)
+
+	for i := 1; i <= 3; i++ {
+		q.Put(i)
+	}
+	if q.Len() != 3 || q.Cap() != 3 {
+		t.Errorf("expected len == cap == 3")
+	}
+
+	// wrap around the end of the buffer
+	job, _ := q.Get()
+	q.Put(4)
+	q.Close()
+
+	expected := []int{1, 2, 3, 4}
+	for _, e := range expected {
+		if job != e {
+			t.Errorf("expected %v, got %v", e, job)
+		}
+		job, _ = q.Get()
+	}
+
+	if _, ok := q.Get(); ok {
+		t.Errorf("expected a closed, empty queue")
+	}
+}
+
+func TestInstrumentedQueue(t *testing.T) {
+	q := pipeline.Instrument(pipeline.NewChanQueue(5))
+
+	for i := 0; i < 5; i++ {
+		q.Put(i)
+	}
+	q.Get()
+	q.Close()
+	for _, ok := q.Get(); ok; _, ok = q.Get() {
+	}
+
+	stats := q.Stats()
+	if stats.Puts != 5 || stats.Gets != 5 || stats.MaxLen != 5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestNewQueueConfig(t *testing.T) {
+	var queues []*pipeline.InstrumentedQueue
+
+	cfg := pipeline.DefaultConfig()
+	cfg.NewQueue = func(capacity int) pipeline.Queue {
+		q := pipeline.Instrument(pipeline.NewRingQueue(capacity))
+		queues = append(queues, q)
+		return q
+	}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	stage := &CountingStage{}
+	p.AddStage(stage)
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	if len(queues) != 2 {
+		t.Fatalf("expected 2 queues, got %v", len(queues))
+	}
+	for _, q := range queues {
+		if q.Stats().Gets != 10 {
+			t.Errorf("expected 10 jobs through each queue")
+		}
+	}
+}
+
+type PriorityJob struct {
+	ID       int
+	priority int
+}
+
+func (j *PriorityJob) Priority() int {
+	return j.priority
+}
+
+func TestPriorityQueue(t *testing.T) {
+	q := pipeline.NewPriorityQueue(0, 0)
+
+	q.Put(&PriorityJob{ID: 1, priority: 0})
+	q.Put(&PriorityJob{ID: 2, priority: 5})
+	q.Put(&PriorityJob{ID: 3, priority: 0})
+	q.Put("not prioritized")
+	q.Put(&PriorityJob{ID: 4, priority: 9})
+	q.Close()
+
+	expected := []interface{}{4, 2, 1, 3, "not prioritized"}
+	for _, e := range expected {
+		job, ok := q.Get()
+		if !ok {
+			t.Fatalf("expected %v", e)
+		}
+		if j, ok := job.(*PriorityJob); ok {
+			job = j.ID
+		}
+		if job != e {
+			t.Errorf("expected %v, got %v", e, job)
+		}
+	}
+}
+
+func TestPriorityQueueAging(t *testing.T) {
+	q := pipeline.NewPriorityQueue(0, time.Millisecond)
+
+	q.Put(&PriorityJob{ID: 1, priority: 0})
+	time.Sleep(20 * time.Millisecond)
+	q.Put(&PriorityJob{ID: 2, priority: 5})
+
+	// the older job has aged past the newer, higher priority job
+	job, _ := q.Get()
+	if job.(*PriorityJob).ID != 1 {
+		t.Errorf("expected the aged job first, got %v", job.(*PriorityJob).ID)
+	}
+}
+
+func drain(q pipeline.Queue) []interface{} {
+	var jobs []interface{}
+	for job, ok := q.Get(); ok; job, ok = q.Get() {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func TestBoundedQueueOverflow(t *testing.T) {
+	tests := []struct {
+		policy   pipeline.Overflow
+		expected []interface{}
+		dropped  uint64
+		spilled  uint64
+	}{
+		{pipeline.DropNewest, []interface{}{1, 2, 3}, 2, 0},
+		{pipeline.DropOldest, []interface{}{3, 4, 5}, 2, 0},
+		{pipeline.Spill, []interface{}{1, 2, 3, 4, 5}, 0, 2},
+	}
+
+	for _, test := range tests {
+		q := pipeline.NewBoundedQueue(3, test.policy, pipeline.NewChanQueue(10))
+		for i := 1; i <= 5; i++ {
+			q.Put(i)
+		}
+		if q.Len() != len(test.expected) {
+			t.Errorf("%v: expected len %v, got %v", test.policy, len(test.expected), q.Len())
+		}
+		q.Close()
+
+		jobs := drain(q)
+		if fmt.Sprint(jobs) != fmt.Sprint(test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.policy, test.expected, jobs)
+		}
+		if q.Dropped() != test.dropped || q.Spilled() != test.spilled {
+			t.Errorf("%v: expected dropped=%v, spilled=%v; got dropped=%v, spilled=%v", test.policy, test.dropped, test.spilled, q.Dropped(), q.Spilled())
+		}
+	}
+}
+
+func TestBoundedQueueSpillOrder(t *testing.T) {
+	q := pipeline.NewBoundedQueue(2, pipeline.Spill, pipeline.NewChanQueue(10))
+
+	q.Put(1)
+	q.Put(2)
+	q.Put(3) // spilled
+	q.Get()
+	q.Put(4) // room in memory but must follow 3
+	q.Close()
+
+	jobs := drain(q)
+	if fmt.Sprint(jobs) != "[2 3 4]" {
+		t.Errorf("expected [2 3 4], got %v", jobs)
+	}
+}
+
+func TestStatsDropped(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	stage := &CountingStage{}
+	p.AddStage(stage)
+
+	block := make(chan struct{})
+	q := pipeline.NewBoundedQueue(1, pipeline.DropNewest, nil)
+	p.SetQueue(0, &GatedQueue{q, block})
+
+	go func() {
+		// let the generator overrun the queue before the stage starts reading
+		for q.Dropped() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		close(block)
+	}()
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	stats := p.Stats()
+	if len(stats.Stages) != 1 || stats.Stages[0].Name != "CountingStage" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.Stages[0].Dropped == 0 || uint64(stage.ProcessCount)+stats.Stages[0].Dropped != 10 {
+		t.Errorf("expected processed + dropped == 10; processed=%v, dropped=%v", stage.ProcessCount, stats.Stages[0].Dropped)
+	}
+}
+
+func TestStatsRejected(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	stage := &CountingStage{}
+	p.AddStage(stage, &CountingStage{})
+	p.SetQueue(0, &RefusingQueue{Queue: pipeline.NewChanQueue(10), Every: 2})
+	p.SetQueue(2, &RefusingQueue{Queue: pipeline.NewChanQueue(10), Every: 1})
+
+	jobs, err := p.Collect()
+	if err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	// half the jobs are refused by the first queue and the rest by the last
+	snap := p.Snapshot()
+	if stage.ProcessCount != 5 || len(jobs) != 0 {
+		t.Errorf("expected 5 jobs processed and none output; processed=%v, output=%v", stage.ProcessCount, jobs)
+	}
+	if ss := snap.Stages[0]; ss.Rejected != 5 || ss.Errors != 0 {
+		t.Errorf("expected 5 rejected by the first queue, got %+v", ss)
+	}
+	if ss := snap.Stages[1]; ss.Rejected != 0 || ss.Errors != 5 {
+		t.Errorf("expected 5 errors sending from the last stage, got %+v", ss)
+	}
+	if rejected := p.Stats().Stages[0].Rejected; rejected != 5 {
+		t.Errorf("expected 5 rejected, got %v", rejected)
+	}
+}
+
+// RefusingQueue refuses every nth job
+type RefusingQueue struct {
+	pipeline.Queue
+	Every int
+	n     int
+}
+
+func (q *RefusingQueue) Put(job interface{}) error {
+	q.n++
+	if q.n%q.Every == 0 {
+		return errors.New("refused")
+	}
+	return q.Queue.Put(job)
+}
+
+// GatedQueue blocks Get until the gate is closed
+type GatedQueue struct {
+	pipeline.Queue
+	gate chan struct{}
+}
+
+func (q *GatedQueue) Get() (interface{}, bool) {
+	<-q.gate
+	return q.Queue.Get()
+}
+
+func (q *GatedQueue) Dropped() uint64 {
+	return q.Queue.(pipeline.OverflowCounter).Dropped()
+}
+
+func (q *GatedQueue) Spilled() uint64 {
+	return q.Queue.(pipeline.OverflowCounter).Spilled()
+}
@@ -0,0 +1,178 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+// Definition describes a pipeline. Depth and Buffered are pointers so that
+// omitting them leaves the configuration passed to Build unchanged.
+type Definition struct {
+	Name          string            `json:"name" yaml:"name"`
+	Generator     Component         `json:"generator" yaml:"generator"`
+	Stages        []StageDefinition `json:"stages" yaml:"stages"`
+	Depth         *int              `json:"depth,omitempty" yaml:"depth,omitempty"`
+	Buffered      *bool             `json:"buffered,omitempty" yaml:"buffered,omitempty"`
+	NoConcurrency bool              `json:"no_concurrency,omitempty" yaml:"no_concurrency,omitempty"`
+	Verbose       bool              `json:"verbose,omitempty" yaml:"verbose,omitempty"`
+}
+
+// Component names a registered factory and the params passed to it
+type Component struct {
+	Type   string `json:"type" yaml:"type"`
+	Params Params `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// StageDefinition describes a stage and the options it is added with
+type StageDefinition struct {
+	Type        string   `json:"type" yaml:"type"`
+	Params      Params   `json:"params,omitempty" yaml:"params,omitempty"`
+	Concurrency int      `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	Retries     int      `json:"retries,omitempty" yaml:"retries,omitempty"`
+	RetryDelay  Duration `json:"retry_delay,omitempty" yaml:"retry_delay,omitempty"`
+	Timeout     Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Partitioned bool     `json:"partitioned,omitempty" yaml:"partitioned,omitempty"`
+}
+
+// Parse decodes a definition with the unmarshaler
+func Parse(data []byte, u Unmarshaler) (*Definition, error) {
+	def := &Definition{}
+	if err := u(data, def); err != nil {
+		return nil, fmt.Errorf("registry: %v", err)
+	}
+	return def, nil
+}
+
+func (def *Definition) apply(cfg *pipeline.Config) {
+	if def.Depth != nil {
+		cfg.Depth = *def.Depth
+	}
+	if def.Buffered != nil {
+		cfg.Buffered = *def.Buffered
+	}
+	cfg.NoConcurrency = cfg.NoConcurrency || def.NoConcurrency
+	cfg.Verbose = cfg.Verbose || def.Verbose
+}
+
+func (sd StageDefinition) options() pipeline.StageOptions {
+	return pipeline.StageOptions{
+		Concurrency: sd.Concurrency,
+		Retries:     sd.Retries,
+		RetryDelay:  time.Duration(sd.RetryDelay),
+		Timeout:     time.Duration(sd.Timeout),
+		Partitioned: sd.Partitioned,
+	}
+}
+
+// Duration is a time.Duration written as a string such as "1m30s"
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Params holds the free-form settings passed to a factory
+type Params map[string]interface{}
+
+// String returns the string param or def if it is not set
+func (p Params) String(key string, def string) (string, error) {
+	v, ok := p[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return def, fmt.Errorf("param %q must be a string", key)
+	}
+	return s, nil
+}
+
+// Int returns the integer param or def if it is not set
+func (p Params) Int(key string, def int) (int, error) {
+	v, ok := p[key]
+	if !ok {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	case string:
+		if i, err := strconv.Atoi(n); err == nil {
+			return i, nil
+		}
+	}
+	return def, fmt.Errorf("param %q must be an integer", key)
+}
+
+// Bool returns the boolean param or def if it is not set
+func (p Params) Bool(key string, def bool) (bool, error) {
+	v, ok := p[key]
+	if !ok {
+		return def, nil
+	}
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		if v, err := strconv.ParseBool(b); err == nil {
+			return v, nil
+		}
+	}
+	return def, fmt.Errorf("param %q must be a boolean", key)
+}
+
+// Duration returns the duration param, written as a string such as "5s", or
+// def if it is not set
+func (p Params) Duration(key string, def time.Duration) (time.Duration, error) {
+	s, err := p.String(key, "")
+	if err != nil || s == "" {
+		return def, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return def, fmt.Errorf("param %q: %v", key, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,268 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package registry builds pipelines from a declarative definition.
+//
+// Stage and generator constructors are registered by name, typically from an
+// init function in the package that implements them:
+//
+//	func init() {
+//		registry.RegisterStage("hash", func(params registry.Params) (pipeline.Stage, error) {
+//			return &Hash{}, nil
+//		})
+//	}
+//
+// A definition, read from a JSON file (or YAML, see SetUnmarshaler), names the
+// generator and stages and tunes the deployment:
+//
+//	{
+//		"generator": {"type": "localfs", "params": {"path": "/data", "match": ".*\\.go$"}},
+//		"depth": 20,
+//		"stages": [
+//			{"type": "hash", "concurrency": 16, "retries": 2, "retry_delay": "100ms"},
+//			{"type": "store", "timeout": "5s"}
+//		]
+//	}
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jboelter/pipeline"
+)
+
+// StageFactory creates a stage from the params in its definition
+type StageFactory func(params Params) (pipeline.Stage, error)
+
+// GeneratorFactory creates a generator from the params in its definition
+type GeneratorFactory func(params Params) (pipeline.Generator, error)
+
+// Unmarshaler decodes a definition; json.Unmarshal and yaml.Unmarshal both
+// qualify
+type Unmarshaler func(data []byte, v interface{}) error
+
+// Registry holds the named stage and generator factories
+type Registry struct {
+	_            struct{}
+	mu           sync.RWMutex
+	stages       map[string]StageFactory
+	generators   map[string]GeneratorFactory
+	unmarshalers map[string]Unmarshaler
+}
+
+// Default is the Registry used by the package level functions
+var Default = New()
+
+// New creates an empty Registry that reads .json definitions
+func New() *Registry {
+	return &Registry{
+		stages:       make(map[string]StageFactory),
+		generators:   make(map[string]GeneratorFactory),
+		unmarshalers: map[string]Unmarshaler{".json": json.Unmarshal},
+	}
+}
+
+// RegisterStage registers a stage factory under name, replacing any existing
+// factory with that name
+func (r *Registry) RegisterStage(name string, f StageFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stages[name] = f
+}
+
+// RegisterGenerator registers a generator factory under name, replacing any
+// existing factory with that name
+func (r *Registry) RegisterGenerator(name string, f GeneratorFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generators[name] = f
+}
+
+// SetUnmarshaler sets the function used to decode definition files with the
+// extension, such as ".yaml" with gopkg.in/yaml.v3's Unmarshal
+func (r *Registry) SetUnmarshaler(ext string, u Unmarshaler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unmarshalers[strings.ToLower(ext)] = u
+}
+
+// Stages returns the registered stage names, sorted
+func (r *Registry) Stages() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.stages))
+	for name := range r.stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Generators returns the registered generator names, sorted
+func (r *Registry) Generators() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.generators))
+	for name := range r.generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadFile reads a definition, choosing the unmarshaler by file extension
+func (r *Registry) ReadFile(path string) (*Definition, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	r.mu.RLock()
+	u, ok := r.unmarshalers[ext]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("registry: no unmarshaler for %q files", ext)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, u)
+}
+
+// Build creates a pipeline from the definition. The definition's settings
+// override those in cfg.
+func (r *Registry) Build(def *Definition, cfg pipeline.Config) (*pipeline.Pipeline, error) {
+	return r.build(def, cfg, false)
+}
+
+// Plan creates the pipeline described by the definition, as Build does, but
+// without calling the generator factory, which may start work such as walking a
+// filesystem. The pipeline can be inspected, with DOT or Mermaid, but its
+// generator is only a name and produces no jobs. The stage factories are called.
+func (r *Registry) Plan(def *Definition, cfg pipeline.Config) (*pipeline.Pipeline, error) {
+	return r.build(def, cfg, true)
+}
+
+func (r *Registry) build(def *Definition, cfg pipeline.Config, plan bool) (*pipeline.Pipeline, error) {
+	if err := r.Validate(def); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	def.apply(&cfg)
+	p := pipeline.NewWithConfig(cfg)
+
+	if plan {
+		p.SetGenerator(planned(def.Generator.Type))
+	} else {
+		g, err := r.generators[def.Generator.Type](def.Generator.Params)
+		if err != nil {
+			return nil, fmt.Errorf("registry: generator %q: %v", def.Generator.Type, err)
+		}
+		p.SetGenerator(g)
+	}
+
+	for i, sd := range def.Stages {
+		s, err := r.stages[sd.Type](sd.Params)
+		if err != nil {
+			return nil, fmt.Errorf("registry: stage %v (%q): %v", i, sd.Type, err)
+		}
+		p.AddStageWithOptions(s, sd.options())
+	}
+	return p, nil
+}
+
+// Validate checks that the definition only refers to registered factories and
+// that its settings are in range
+func (r *Registry) Validate(def *Definition) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var problems []string
+	if def.Generator.Type == "" {
+		problems = append(problems, "the generator has no type")
+	} else if _, ok := r.generators[def.Generator.Type]; !ok {
+		problems = append(problems, fmt.Sprintf("unknown generator %q", def.Generator.Type))
+	}
+
+	if len(def.Stages) == 0 {
+		problems = append(problems, "there are no stages defined")
+	}
+	if def.Depth != nil && *def.Depth < 0 {
+		problems = append(problems, "depth cannot be negative")
+	}
+
+	for i, sd := range def.Stages {
+		if _, ok := r.stages[sd.Type]; !ok {
+			problems = append(problems, fmt.Sprintf("stage %v: unknown stage %q", i, sd.Type))
+		}
+		if sd.Concurrency < 0 || sd.Retries < 0 || sd.RetryDelay < 0 || sd.Timeout < 0 {
+			problems = append(problems, fmt.Sprintf("stage %v: concurrency, retries, retry_delay and timeout cannot be negative", i))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("registry: invalid definition: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// planned stands in for the generator of a pipeline created by Plan
+type planned string
+
+func (g planned) Name() string {
+	return string(g)
+}
+
+func (g planned) Next() interface{} {
+	return nil
+}
+
+func (g planned) Abort() {
+}
+
+// RegisterStage registers a stage factory with the Default registry
+func RegisterStage(name string, f StageFactory) {
+	Default.RegisterStage(name, f)
+}
+
+// RegisterGenerator registers a generator factory with the Default registry
+func RegisterGenerator(name string, f GeneratorFactory) {
+	Default.RegisterGenerator(name, f)
+}
+
+// ReadFile reads a definition using the Default registry's unmarshalers
+func ReadFile(path string) (*Definition, error) {
+	return Default.ReadFile(path)
+}
+
+// Build creates a pipeline from the definition using the Default registry
+func Build(def *Definition, cfg pipeline.Config) (*pipeline.Pipeline, error) {
+	return Default.Build(def, cfg)
+}
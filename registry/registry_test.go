@@ -0,0 +1,218 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/registry"
+)
+
+type counter struct {
+	n, limit int
+}
+
+func (g *counter) Name() string {
+	return "counter"
+}
+
+func (g *counter) Next() interface{} {
+	g.n++
+	if g.n <= g.limit {
+		return g.n
+	}
+	return nil
+}
+
+func (g *counter) Abort() {
+}
+
+type sum struct {
+	total int
+}
+
+func (s *sum) Name() string {
+	return "sum"
+}
+
+func (s *sum) Concurrency() int {
+	return 1
+}
+
+func (s *sum) Process(i interface{}) {
+	s.total += i.(int)
+}
+
+func newRegistry(s *sum) *registry.Registry {
+	r := registry.New()
+	r.RegisterGenerator("counter", func(params registry.Params) (pipeline.Generator, error) {
+		limit, err := params.Int("limit", 10)
+		return &counter{limit: limit}, err
+	})
+	r.RegisterStage("sum", func(params registry.Params) (pipeline.Stage, error) {
+		return s, nil
+	})
+	return r
+}
+
+const definition = `{
+	"name": "sums",
+	"generator": {"type": "counter", "params": {"limit": 4}},
+	"depth": 3,
+	"stages": [
+		{"type": "sum", "concurrency": 1, "retries": 2, "retry_delay": "10ms", "timeout": "1s", "partitioned": true}
+	]
+}`
+
+func TestBuild(t *testing.T) {
+	s := &sum{}
+	r := newRegistry(s)
+
+	def, err := registry.Parse([]byte(definition), json.Unmarshal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Name != "sums" || *def.Depth != 3 || time.Duration(def.Stages[0].RetryDelay) != 10*time.Millisecond || !def.Stages[0].Partitioned {
+		t.Errorf("unexpected definition %+v", def)
+	}
+
+	p, err := r.Build(def, pipeline.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(p.DOT(), `n0 -> n1 [label="depth=1"]`) {
+		t.Errorf("unexpected topology %v", p.DOT())
+	}
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if s.total != 10 {
+		t.Errorf("expected the sum of 1..4, got %v", s.total)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	r := newRegistry(&sum{})
+
+	def, _ := registry.Parse([]byte(`{
+		"generator": {"type": "nope"},
+		"stages": [{"type": "sum"}, {"type": "missing", "retries": -1}]
+	}`), json.Unmarshal)
+
+	err := r.Validate(def)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, problem := range []string{`unknown generator "nope"`, `stage 1: unknown stage "missing"`, "cannot be negative"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q in %v", problem, err)
+		}
+	}
+
+	if _, err := r.Build(def, pipeline.DefaultConfig()); err == nil {
+		t.Errorf("expected Build to fail validation")
+	}
+	if _, err := r.Plan(def, pipeline.DefaultConfig()); err == nil {
+		t.Errorf("expected Plan to fail validation")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	r := newRegistry(&sum{})
+	r.RegisterGenerator("counter", func(registry.Params) (pipeline.Generator, error) {
+		t.Errorf("expected the generator not to be created")
+		return &counter{}, nil
+	})
+
+	def, err := registry.Parse([]byte(definition), json.Unmarshal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Plan(def, pipeline.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(p.DOT(), `n0 [label="counter" shape=ellipse]`) {
+		t.Errorf("unexpected topology %v", p.DOT())
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newRegistry(&sum{})
+
+	path := filepath.Join(dir, "pipeline.yml")
+	ioutil.WriteFile(path, []byte(definition), 0644)
+	if _, err := r.ReadFile(path); err == nil {
+		t.Errorf("expected an error without a .yml unmarshaler")
+	}
+
+	// JSON is a subset of YAML; stands in for yaml.Unmarshal
+	r.SetUnmarshaler(".yml", json.Unmarshal)
+	def, err := r.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Generator.Type != "counter" || len(def.Stages) != 1 {
+		t.Errorf("unexpected definition %+v", def)
+	}
+
+	if names := r.Stages(); len(names) != 1 || names[0] != "sum" {
+		t.Errorf("unexpected stages %v", names)
+	}
+}
+
+func TestParams(t *testing.T) {
+	params := registry.Params{"n": float64(3), "s": "x", "b": true, "d": "2s", "bad": 1.5}
+
+	if n, err := params.Int("n", 0); n != 3 || err != nil {
+		t.Errorf("unexpected Int %v, %v", n, err)
+	}
+	if _, err := params.Int("bad", 0); err == nil {
+		t.Errorf("expected an error for a fractional Int")
+	}
+	if s, _ := params.String("s", ""); s != "x" {
+		t.Errorf("unexpected String %v", s)
+	}
+	if b, _ := params.Bool("b", false); !b {
+		t.Errorf("unexpected Bool %v", b)
+	}
+	if d, _ := params.Duration("d", 0); d != 2*time.Second {
+		t.Errorf("unexpected Duration %v", d)
+	}
+	if d, _ := params.Duration("missing", time.Minute); d != time.Minute {
+		t.Errorf("expected the default Duration, got %v", d)
+	}
+}
@@ -0,0 +1,271 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ErrVirtualClock is returned by Simulate when Config.Clock is set to a Clock
+// other than a VirtualClock.
+var ErrVirtualClock = errors.New("pipeline: Simulate requires a VirtualClock")
+
+// Simulate runs the pipeline deterministically in virtual time. It is a
+// discrete event simulation on a single goroutine: each step pulls a job from
+// the generator or runs one job through one worker of a stage. Steps run in
+// order of the virtual time they can start and ties, such as which idle worker
+// takes the next job, are broken by a random source seeded with seed; the same
+// seed reproduces the same interleaving.
+//
+// Time only moves when a step sleeps (or waits on a timer) on the pipeline's
+// VirtualClock, which a Clocked stage is given before the simulation starts.
+// A worker is busy from the time its step starts until the virtual time at
+// which Process returns, so concurrency, timeouts and retry delays behave as
+// they would in Run. Seeded stages are given random sources derived from seed.
+//
+// Queues are modeled as FIFO buffers with the capacity of the queue they
+// stand in for; the Queue implementations themselves are not used. The jobs
+// leaving the final stage are passed to Config.Output in virtual time order.
+// Pause, Resume and Resize do not apply to a simulation.
+func (p *Pipeline) Simulate(seed int64) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+
+	if p.config.Clock == nil {
+		p.config.Clock = NewVirtualClock(time.Unix(0, 0).UTC())
+	}
+	clock, ok := p.config.Clock.(*VirtualClock)
+	if !ok {
+		return ErrVirtualClock
+	}
+
+	if p.config.Logger != nil && p.config.Verbose {
+		p.config.Logger.Printf("source=pipeline, action=simulating, seed=%v\n", seed)
+	}
+
+	clock.mu.Lock()
+	clock.auto = true
+	clock.mu.Unlock()
+	defer func() {
+		clock.mu.Lock()
+		clock.auto = false
+		clock.mu.Unlock()
+	}()
+
+	p.inject(seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	// buffers[i] feeds stage i; the output of the final stage is not buffered
+	buffers := make([][]simJob, len(p.runners)+1)
+	limits := make([]int, len(p.runners)+1)
+	for i := range limits {
+		limits[i] = -1
+		if c, ok := p.queues[i].(capacity); ok && i < len(p.runners) {
+			limits[i] = c.Cap()
+			if limits[i] == 0 {
+				limits[i] = 1 // an unbuffered hand-off
+			}
+		}
+	}
+	room := func(i int) bool {
+		return limits[i] < 0 || len(buffers[i]) < limits[i]
+	}
+
+	// enqueue buffers a job for stage i, or the first stage after it that does
+	// not skip the job, fixing the worker that takes it when the stage is
+	// partitioned; a job that skips the final stage is output
+	enqueue := func(i int, job interface{}) {
+		if i = p.skip(i, job); i == len(p.runners) {
+			if p.config.Output != nil {
+				p.config.Output(Unwrap(job))
+			}
+			return
+		}
+		sj := simJob{job: job, ready: clock.Now(), worker: -1}
+		if r := p.runners[i]; r.partitioned() {
+			sj.worker = r.partition(job, p.concurrency(r))
+		}
+		buffers[i] = append(buffers[i], sj)
+	}
+
+	// jobs leaving stage i, whether passed on or emitted, are buffered for the
+	// next stage as of the current virtual time
+	forward := func(i int, job interface{}) {
+		enqueue(i+1, job)
+	}
+	emits := make([]bool, len(p.runners))
+	for i, r := range p.runners {
+		_, emits[i] = r.stage.(Emitter)
+		r.emit = func(i int) func(interface{}) {
+			return func(job interface{}) {
+				forward(i, job)
+			}
+		}(i)
+	}
+
+	// free[i][w] is the virtual time worker w of stage i is next idle
+	free := make([][]time.Time, len(p.runners))
+	start := clock.Now()
+	for i, r := range p.runners {
+		n := p.concurrency(r)
+		free[i] = make([]time.Time, n)
+		for w := range free[i] {
+			free[i][w] = start
+		}
+		r.mu.Lock()
+		r.workers = n
+		r.mu.Unlock()
+		atomic.StoreInt32(&r.active, int32(n))
+	}
+
+	atomic.StoreInt32(&p.running, 1)
+	defer func() {
+		for _, r := range p.runners {
+			atomic.StoreInt32(&r.active, 0)
+		}
+		atomic.StoreInt32(&p.running, 0)
+	}()
+
+	generated, generating, finished := start, true, start
+	flushed := 0
+	var ties []simStep
+	for {
+		// find the steps that can start the earliest
+		ties = ties[:0]
+		consider := func(s simStep) {
+			if len(ties) > 0 && s.at.After(ties[0].at) {
+				return
+			}
+			if len(ties) > 0 && s.at.Before(ties[0].at) {
+				ties = ties[:0]
+			}
+			ties = append(ties, s)
+		}
+
+		if generating && room(0) {
+			consider(simStep{at: generated, stage: -1})
+		}
+		for i := range p.runners {
+			if len(buffers[i]) == 0 || !room(i+1) {
+				continue
+			}
+			ready, only := buffers[i][0].ready, buffers[i][0].worker
+			for w, f := range free[i] {
+				if only >= 0 && w != only {
+					continue
+				}
+				at := f
+				if ready.After(at) {
+					at = ready
+				}
+				consider(simStep{at: at, stage: i, worker: w})
+			}
+		}
+
+		if len(ties) == 0 {
+			// leave the clock at the time the last step finished
+			clock.jump(finished)
+
+			// every job has moved through the stages; flush them in order, which
+			// may emit more jobs for the stages downstream
+			if flushed < len(p.runners) {
+				p.flush(p.runners[flushed])
+				flushed++
+				continue
+			}
+			break
+		}
+		step := ties[rng.Intn(len(ties))]
+		clock.jump(step.at)
+
+		if step.stage < 0 {
+			job := p.generator.Next()
+			generated = clock.Now()
+			if generated.After(finished) {
+				finished = generated
+			}
+			if job == nil {
+				if p.config.Logger != nil && p.config.Verbose {
+					p.config.Logger.Println("source=pipeline, action=closing")
+				}
+				generating = false
+				continue
+			}
+			enqueue(0, p.envelope(job))
+			continue
+		}
+
+		i, r := step.stage, p.runners[step.stage]
+		job := buffers[i][0].job
+		buffers[i][0] = simJob{}
+		buffers[i] = buffers[i][1:]
+
+		r.begin(step.worker, step.at)
+		ok := p.simulate(r, job, step.at)
+		r.end(step.worker)
+		p.record(r, job, step.at)
+
+		free[i][step.worker] = clock.Now()
+		if clock.Now().After(finished) {
+			finished = clock.Now()
+		}
+		if ok && !emits[i] {
+			forward(i, job)
+		}
+	}
+
+	if p.config.Logger != nil && p.config.Verbose {
+		p.config.Logger.Println("source=pipeline, action=terminating")
+	}
+	return nil
+}
+
+// simulate runs the job through the stage in virtual time; a job that ran
+// past the timeout is counted and dropped as it would have been by Run
+func (p *Pipeline) simulate(r *runner, job interface{}, start time.Time) bool {
+	err := p.try(r, job)
+	if _, ok := r.stage.(Emitter); !ok && r.opts.Timeout > 0 && p.clock().Now().Sub(start) > r.opts.Timeout {
+		p.fail(r, ErrTimeout)
+		return false
+	}
+	if err != nil {
+		p.fail(r, err)
+	}
+	return true
+}
+
+type simJob struct {
+	job    interface{}
+	ready  time.Time
+	worker int // the only worker that may take the job, or -1 for any
+}
+
+type simStep struct {
+	at     time.Time
+	stage  int // -1 for the generator
+	worker int
+}
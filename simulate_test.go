@@ -0,0 +1,171 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+func simulate(t *testing.T, seed int64) ([]string, *pipeline.Pipeline, pipeline.Clock) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+
+	trace := &TracingStage{}
+	p.AddStage(trace)
+
+	if err := p.Simulate(seed); err != nil {
+		t.Fatal(err)
+	}
+	return trace.Trace, p, clock
+}
+
+func TestSimulateDeterministic(t *testing.T) {
+	first, _, _ := simulate(t, 42)
+	second, _, _ := simulate(t, 42)
+
+	if len(first) != 10 {
+		t.Fatalf("expected 10 jobs, got %v", first)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected the same seed to reproduce the trace\n%v\n%v", first, second)
+	}
+
+	different := false
+	for seed := int64(1); seed < 10 && !different; seed++ {
+		other, _, _ := simulate(t, seed)
+		different = !reflect.DeepEqual(first, other)
+	}
+	if !different {
+		t.Errorf("expected other seeds to produce other interleavings")
+	}
+}
+
+func TestSimulateVirtualTime(t *testing.T) {
+	_, p, clock := simulate(t, 1)
+
+	// 10 jobs with random delays under 100ms across 3 workers; the simulation
+	// takes no wall time but the virtual clock has moved on
+	elapsed := clock.Now().Sub(time.Unix(0, 0))
+	if elapsed <= 0 || elapsed >= time.Second {
+		t.Errorf("unexpected virtual elapsed time %v", elapsed)
+	}
+
+	ss := p.Snapshot().Stages[0]
+	if ss.Processed != 10 || ss.Workers != 3 || ss.Active != 0 {
+		t.Errorf("unexpected stage snapshot %+v", ss)
+	}
+}
+
+func TestSimulateConcurrency(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStageWithOptions(&SleepingStage{Sleep: time.Second}, pipeline.StageOptions{Concurrency: 2})
+	p.AddStageWithOptions(&SleepingStage{Sleep: time.Second}, pipeline.StageOptions{Concurrency: 5, Timeout: 500 * time.Millisecond})
+
+	if err := p.Simulate(7); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 one second jobs on 2 workers, then the last pair through the next stage
+	if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed != 6*time.Second {
+		t.Errorf("expected 6s of virtual time, got %v", elapsed)
+	}
+	if errs := p.Snapshot().Stages[1].Errors; errs != 10 {
+		t.Errorf("expected 10 timeouts, got %v", errs)
+	}
+}
+
+func TestSimulateOutput(t *testing.T) {
+	var jobs []interface{}
+	cfg := pipeline.DefaultConfig()
+	cfg.Output = func(job interface{}) {
+		jobs = append(jobs, job)
+	}
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{})
+
+	if err := p.Simulate(1); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 10 {
+		t.Errorf("expected 10 jobs, got %v", jobs)
+	}
+}
+
+func TestSimulateClock(t *testing.T) {
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = pipeline.SystemClock{}
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{})
+
+	if err := p.Simulate(1); err != pipeline.ErrVirtualClock {
+		t.Errorf("expected ErrVirtualClock")
+	}
+}
+
+/* test stage */
+type TracingStage struct {
+	Trace []string
+	clock pipeline.Clock
+	rand  *rand.Rand
+}
+
+func (s *TracingStage) SetClock(c pipeline.Clock) {
+	s.clock = c
+}
+
+func (s *TracingStage) SetRand(r *rand.Rand) {
+	s.rand = r
+}
+
+func (s *TracingStage) Name() string {
+	return "TracingStage"
+}
+
+func (s *TracingStage) Concurrency() int {
+	return 3
+}
+
+func (s *TracingStage) Process(job interface{}) {
+	start := s.clock.Now()
+	s.clock.Sleep(time.Duration(s.rand.Intn(100)) * time.Millisecond)
+	s.Trace = append(s.Trace, fmt.Sprintf("%v@%v-%v", job, start.UnixNano()/1e6, s.clock.Now().UnixNano()/1e6))
+}
@@ -0,0 +1,47 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import "sync/atomic"
+
+// skips reports whether the job bypasses the stage
+func (r *runner) skips(job interface{}) bool {
+	return r.opts.When != nil && !r.opts.When(Unwrap(job))
+}
+
+// skip returns the index of the first stage, from i, that processes the job,
+// counting it as skipped by the stages before; len(p.runners) means the job
+// skips every remaining stage
+func (p *Pipeline) skip(i int, job interface{}) int {
+	for ; i < len(p.runners) && p.runners[i].skips(job); i++ {
+		atomic.AddUint64(&p.runners[i].skipped, 1)
+	}
+	return i
+}
+
+// send puts the job in the queue feeding stage i, or past the stages that skip
+// it. The queues are closed in order, each after the stage feeding it has
+// finished, so a job may be put past a stage whose input is still open.
+func (p *Pipeline) send(i int, job interface{}, from *runner) {
+	p.put(p.skip(i, job), job, from)
+}
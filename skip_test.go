@@ -0,0 +1,137 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+func even(job interface{}) bool {
+	return job.(int)%2 == 0
+}
+
+func TestWhen(t *testing.T) {
+	first, last := &CountingStage{}, &CountingStage{}
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStageWithOptions(first, pipeline.StageOptions{When: even})
+	p.AddStage(&CountingStage{})
+	p.AddStageWithOptions(last, pipeline.StageOptions{When: func(interface{}) bool { return false }})
+
+	jobs, err := p.Collect()
+	if err != nil {
+		t.Errorf(`error should be nil`)
+	}
+
+	// skipped jobs still flow on, past the final stage to the output
+	if len(jobs) != 10 {
+		t.Errorf("expected 10 jobs, got %v", jobs)
+	}
+	if first.ProcessCount != 5 || last.ProcessCount != 0 {
+		t.Errorf("expected 5 and 0 jobs processed; got %v and %v", first.ProcessCount, last.ProcessCount)
+	}
+
+	snap := p.Snapshot()
+	for i, skipped := range []uint64{5, 0, 10} {
+		if ss := snap.Stages[i]; ss.Skipped != skipped || ss.Processed != 10-skipped {
+			t.Errorf("stage %v: expected %v skipped, got %+v", i, skipped, ss)
+		}
+	}
+}
+
+func TestWhenBypassesWorkers(t *testing.T) {
+	held := &HeldStage{Release: make(chan struct{})}
+	var outputs int32
+	cfg := pipeline.DefaultConfig()
+	cfg.Output = func(interface{}) {
+		// every job but the first skips the blocked stage
+		if atomic.AddInt32(&outputs, 1) == 9 {
+			close(held.Release)
+		}
+	}
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStageWithOptions(held, pipeline.StageOptions{When: func(job interface{}) bool { return job == 1 }})
+
+	if err := p.Run(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if held.TimedOut {
+		t.Errorf("expected the skipped jobs to pass the blocked worker")
+	}
+	if outputs != 10 {
+		t.Errorf("expected 10 jobs, got %v", outputs)
+	}
+}
+
+func TestSimulateWhen(t *testing.T) {
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = pipeline.NewVirtualClock(time.Unix(0, 0))
+	var jobs []interface{}
+	cfg.Output = func(job interface{}) {
+		jobs = append(jobs, job)
+	}
+
+	stage := &CountingStage{}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStageWithOptions(stage, pipeline.StageOptions{When: even})
+	p.AddStageWithOptions(&CountingStage{}, pipeline.StageOptions{When: even})
+
+	if err := p.Simulate(3); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 10 || stage.ProcessCount != 5 {
+		t.Errorf("expected 10 jobs and 5 processed; got %v and %v", jobs, stage.ProcessCount)
+	}
+	if skipped := p.Snapshot().Stages[1].Skipped; skipped != 5 {
+		t.Errorf("expected 5 skipped, got %v", skipped)
+	}
+}
+
+/* test stage */
+type HeldStage struct {
+	Release  chan struct{}
+	TimedOut bool
+}
+
+func (s *HeldStage) Name() string {
+	return "HeldStage"
+}
+
+func (s *HeldStage) Concurrency() int {
+	return 1
+}
+
+func (s *HeldStage) Process(interface{}) {
+	select {
+	case <-s.Release:
+	case <-time.After(5 * time.Second):
+		s.TimedOut = true
+	}
+}
@@ -0,0 +1,78 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+// Handle controls a pipeline launched with Start
+type Handle struct {
+	_    struct{}
+	p    *Pipeline
+	done chan struct{}
+}
+
+// Start launches the pipeline and returns without waiting for it to complete.
+// It returns an error, and no Handle, if the pipeline cannot be started. The
+// jobs leaving the final stage are passed to Config.Output, if set.
+func (p *Pipeline) Start() (*Handle, error) {
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+
+	h := &Handle{
+		p:    p,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(h.done)
+		p.drain(nil)
+	}()
+	return h, nil
+}
+
+// Wait blocks until the pipeline has completed. Once started a pipeline runs
+// to completion, so the error is nil as it is for Run; it is returned so a
+// Handle fits an errgroup style supervisor.
+func (h *Handle) Wait() error {
+	<-h.done
+	return nil
+}
+
+// Done returns a channel that is closed when the pipeline has completed
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Abort gracefully terminates the pipeline; use Wait or Done to know when it
+// has drained
+func (h *Handle) Abort() error {
+	return h.p.Abort()
+}
+
+// Stats returns the queue counters of the pipeline
+func (h *Handle) Stats() Stats {
+	return h.p.Stats()
+}
+
+// Snapshot returns the state of the pipeline
+func (h *Handle) Snapshot() Snapshot {
+	return h.p.Snapshot()
+}
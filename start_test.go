@@ -0,0 +1,97 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+func TestStart(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	counting := &CountingStage{}
+	p.AddStage(counting)
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf(`pipeline did not complete`)
+	}
+
+	if err := h.Wait(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if counting.ProcessCount != 10 {
+		t.Errorf("expected 10 jobs, got %v", counting.ProcessCount)
+	}
+	if s := h.Snapshot(); s.Running || s.Stages[0].Processed != 10 {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+}
+
+func TestStartAbort(t *testing.T) {
+	generator := &AbortableGenerator{QuitChan: make(chan struct{})}
+	p := pipeline.New()
+	p.SetGenerator(generator)
+	p.AddStage(&CountingStage{})
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+
+	select {
+	case <-h.Done():
+		t.Fatalf(`pipeline should still be running`)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if len(h.Stats().Stages) != 1 {
+		t.Errorf(`expected stats for 1 stage`)
+	}
+
+	if err := h.Abort(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if err := h.Wait(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+	if generator.AbortCount != 1 {
+		t.Errorf(`the generator should be aborted once`)
+	}
+}
+
+func TestStartNoGenerator(t *testing.T) {
+	h, err := pipeline.New().Start()
+	if err != pipeline.ErrNilGenerator || h != nil {
+		t.Errorf(`the pipeline generator should be nil`)
+	}
+}
@@ -0,0 +1,162 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the counters for a Pipeline
+type Stats struct {
+	_      struct{}
+	Stages []StageStats
+}
+
+// StageStats holds the counters for the queue feeding a stage. Rejected counts
+// the jobs lost because the queue returned an error from Put.
+type StageStats struct {
+	_        struct{}
+	Name     string
+	Queued   int
+	Dropped  uint64
+	Spilled  uint64
+	Rejected uint64
+}
+
+// Stats returns the counters for each stage, in the order the stages were
+// added. It is safe to call while the pipeline is running.
+func (p *Pipeline) Stats() Stats {
+	stats := Stats{Stages: make([]StageStats, len(p.stages))}
+	for idx, s := range p.stages {
+		q := p.queues[idx]
+		ss := StageStats{
+			Name:     s.Name(),
+			Queued:   q.Len(),
+			Rejected: atomic.LoadUint64(&p.runners[idx].rejected),
+		}
+		if oc, ok := q.(OverflowCounter); ok {
+			ss.Dropped, ss.Spilled = oc.Dropped(), oc.Spilled()
+		}
+
+		// the jobs handed to the workers of a partitioned stage are still queued
+		r := p.runners[idx]
+		r.mu.Lock()
+		for _, c := range r.parts {
+			ss.Queued += len(c)
+		}
+		r.mu.Unlock()
+
+		stats.Stages[idx] = ss
+	}
+	return stats
+}
+
+// Snapshot describes a Pipeline at a point in time
+type Snapshot struct {
+	_         struct{}
+	Running   bool
+	Paused    bool
+	Generator string
+	Stages    []StageSnapshot
+}
+
+// StageSnapshot describes a stage at a point in time. Workers is the
+// configured concurrency and Active the number of worker goroutines currently
+// running. Queued and Capacity describe the queue feeding the stage; Capacity
+// is -1 when the queue is unbounded or does not report a capacity.
+// OldestInFlight is how long the longest running job has been in Process.
+// Blocked is the number of workers waiting to hand a job to a full downstream
+// queue. Errors counts jobs that failed after their retries or timed out. Skipped
+// counts jobs that bypassed the stage because of StageOptions.When.
+type StageSnapshot struct {
+	_              struct{}
+	Name           string
+	Paused         bool
+	Workers        int
+	Active         int
+	Queued         int
+	Capacity       int
+	Processed      uint64
+	InFlight       int
+	OldestInFlight time.Duration
+	Blocked        int
+	Errors         uint64
+	Skipped        uint64
+	Dropped        uint64
+	Spilled        uint64
+	Rejected       uint64
+}
+
+// Snapshot reports the state of each stage, in the order the stages were
+// added. It is safe to call while the pipeline is running.
+func (p *Pipeline) Snapshot() Snapshot {
+	snap := Snapshot{
+		Running: atomic.LoadInt32(&p.running) == 1,
+		Paused:  p.Paused(),
+		Stages:  make([]StageSnapshot, len(p.stages)),
+	}
+	if p.generator != nil {
+		snap.Generator = p.generator.Name()
+	}
+
+	now := p.clock().Now()
+	stats := p.Stats()
+	for idx, r := range p.runners {
+		ss := StageSnapshot{
+			Name:     stats.Stages[idx].Name,
+			Paused:   r.gate.isPaused(),
+			Active:   int(atomic.LoadInt32(&r.active)),
+			Blocked:  int(atomic.LoadInt32(&r.blocked)),
+			Errors:   atomic.LoadUint64(&r.errors),
+			Skipped:  atomic.LoadUint64(&r.skipped),
+			Queued:   stats.Stages[idx].Queued,
+			Capacity: -1,
+			Dropped:  stats.Stages[idx].Dropped,
+			Spilled:  stats.Stages[idx].Spilled,
+			Rejected: stats.Stages[idx].Rejected,
+		}
+		if c, ok := p.queues[idx].(capacity); ok {
+			ss.Capacity = c.Cap()
+		}
+
+		r.mu.Lock()
+		ss.Workers = r.workers
+		ss.Processed = r.processed
+		ss.InFlight = len(r.inflight)
+		for _, start := range r.inflight {
+			if d := now.Sub(start); d > ss.OldestInFlight {
+				ss.OldestInFlight = d
+			}
+		}
+		r.mu.Unlock()
+
+		snap.Stages[idx] = ss
+	}
+	return snap
+}
+
+// capacity may be implemented by a Queue to report how many jobs it can hold
+type capacity interface {
+	Cap() int
+}
@@ -0,0 +1,275 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrStalled is returned by Live when a stage with work to do has not
+// completed a job within StreamConfig.Stall
+var ErrStalled = errors.New("pipeline: stage stalled")
+
+// StreamConfig enables the streaming mode for pipelines whose generator runs
+// forever. Every Interval (1s by default) the pipeline samples its counters to
+// keep a rolling Window (1m by default) of throughput and the progress of
+// each stage; Health reports them. Heartbeat, when greater than 0, logs the
+// samples to Config.Logger at that interval. Idle, when greater than 0, marks
+// the pipeline idle once the generator has not produced a job for that long
+// and calls OnIdle, if set. Stall, when greater than 0, fails Live when a stage
+// with queued or in-flight jobs has not completed one for that long; a paused
+// stage, any stage while the pipeline is paused, and a stage waiting for room
+// in a full downstream queue are never stalled.
+type StreamConfig struct {
+	_         struct{}
+	Interval  time.Duration
+	Window    time.Duration
+	Heartbeat time.Duration
+	Idle      time.Duration
+	Stall     time.Duration
+	OnIdle    func(idle time.Duration)
+}
+
+func (c StreamConfig) enabled() bool {
+	return c.Interval > 0 || c.Window > 0 || c.Heartbeat > 0 || c.Idle > 0 || c.Stall > 0 || c.OnIdle != nil
+}
+
+// Health reports the progress of a streaming pipeline. LastJob is the time the
+// generator last produced a job; Rate is in jobs per second over the window.
+type Health struct {
+	_         struct{}
+	Generated uint64
+	LastJob   time.Time
+	Idle      bool
+	Rate      float64
+	Stages    []StageHealth
+}
+
+// StageHealth reports the progress of a single stage
+type StageHealth struct {
+	_            struct{}
+	Name         string
+	Processed    uint64
+	Rate         float64
+	LastProgress time.Time
+	Stalled      bool
+}
+
+// Health returns the progress of the pipeline as of the last sample. The
+// rates and stall detection require Config.Stream; without it only the
+// counters are reported.
+func (p *Pipeline) Health() Health {
+	p.mu.Lock()
+	m := p.monitor
+	p.mu.Unlock()
+	if m != nil {
+		return m.health()
+	}
+
+	snap := p.Snapshot()
+	h := Health{Stages: make([]StageHealth, len(snap.Stages))}
+	for i, s := range snap.Stages {
+		h.Stages[i] = StageHealth{Name: s.Name, Processed: s.Processed}
+	}
+	return h
+}
+
+// Live returns ErrStalled, naming the first stalled stage, if any stage has
+// stopped making progress
+func (p *Pipeline) Live() error {
+	for _, s := range p.Health().Stages {
+		if s.Stalled {
+			return fmt.Errorf("%w: stage '%v'", ErrStalled, s.Name)
+		}
+	}
+	return nil
+}
+
+// monitor samples a running pipeline for the streaming mode
+type monitor struct {
+	_         struct{}
+	p         *Pipeline
+	cfg       StreamConfig
+	quit      chan struct{}
+	done      chan struct{}
+	mu        sync.Mutex
+	generated uint64
+	lastJob   time.Time
+	idle      bool
+	samples   []sample // oldest first, spanning the window
+	progress  []time.Time
+	stalled   []bool
+	beat      time.Time
+}
+
+type sample struct {
+	at        time.Time
+	generated uint64
+	processed []uint64
+}
+
+func newMonitor(p *Pipeline, cfg StreamConfig) *monitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+
+	now := p.clock().Now()
+	m := &monitor{
+		p:        p,
+		cfg:      cfg,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		lastJob:  now,
+		progress: make([]time.Time, len(p.runners)),
+		stalled:  make([]bool, len(p.runners)),
+		beat:     now,
+	}
+	for i := range m.progress {
+		m.progress[i] = now
+	}
+	return m
+}
+
+// generate records a job produced by the generator
+func (m *monitor) generate(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generated++
+	m.lastJob = now
+	m.idle = false
+}
+
+func (m *monitor) run() {
+	defer close(m.done)
+	m.sample()
+	for {
+		t := m.p.clock().NewTimer(m.cfg.Interval)
+		select {
+		case <-t.C():
+			m.sample()
+		case <-m.quit:
+			t.Stop()
+			m.sample()
+			return
+		}
+	}
+}
+
+func (m *monitor) stop() {
+	close(m.quit)
+	<-m.done
+}
+
+func (m *monitor) sample() {
+	snap := m.p.Snapshot()
+	now := m.p.clock().Now()
+
+	m.mu.Lock()
+	s := sample{at: now, generated: m.generated, processed: make([]uint64, len(snap.Stages))}
+	for i, ss := range snap.Stages {
+		s.processed[i] = ss.Processed
+
+		// a stage held up by a full downstream queue is waiting on that stage
+		busy := !snap.Paused && !ss.Paused && ss.Blocked == 0 && (ss.Queued > 0 || ss.InFlight > 0)
+		if !busy || (len(m.samples) > 0 && m.samples[len(m.samples)-1].processed[i] != ss.Processed) {
+			m.progress[i] = now
+		}
+		m.stalled[i] = m.cfg.Stall > 0 && now.Sub(m.progress[i]) > m.cfg.Stall
+	}
+	m.samples = append(m.samples, s)
+
+	// keep the newest sample at or beyond the window as the base for the rates
+	for len(m.samples) > 2 && now.Sub(m.samples[1].at) >= m.cfg.Window {
+		m.samples = m.samples[1:]
+	}
+
+	var onIdle func(time.Duration)
+	idle := now.Sub(m.lastJob)
+	if m.cfg.Idle > 0 && !m.idle && idle >= m.cfg.Idle {
+		m.idle = true
+		onIdle = m.cfg.OnIdle
+		if m.p.config.Logger != nil {
+			m.p.config.Logger.Printf("source=pipeline, action=idle, idle=%v\n", idle)
+		}
+	}
+
+	heartbeat := m.cfg.Heartbeat > 0 && now.Sub(m.beat) >= m.cfg.Heartbeat
+	if heartbeat {
+		m.beat = now
+	}
+	m.mu.Unlock()
+
+	if heartbeat && m.p.config.Logger != nil {
+		h := m.health()
+		m.p.config.Logger.Printf("source=pipeline, action=heartbeat, generated=%v, rate=%.2f, idle=%v\n", h.Generated, h.Rate, h.Idle)
+		for i, s := range h.Stages {
+			m.p.config.Logger.Printf("source=pipeline, stage='%v', action=heartbeat, processed=%v, rate=%.2f, queued=%v, stalled=%v\n", s.Name, s.Processed, s.Rate, snap.Stages[i].Queued, s.Stalled)
+		}
+	}
+
+	if onIdle != nil {
+		onIdle(idle)
+	}
+}
+
+func (m *monitor) health() Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := Health{
+		Generated: m.generated,
+		LastJob:   m.lastJob,
+		Idle:      m.idle,
+		Stages:    make([]StageHealth, len(m.progress)),
+	}
+	if len(m.samples) == 0 {
+		return h
+	}
+
+	first, last := m.samples[0], m.samples[len(m.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	rate := func(from, to uint64) float64 {
+		if elapsed <= 0 {
+			return 0
+		}
+		return float64(to-from) / elapsed
+	}
+
+	h.Rate = rate(first.generated, last.generated)
+	for i, r := range m.p.runners {
+		h.Stages[i] = StageHealth{
+			Name:         r.stage.Name(),
+			Processed:    last.processed[i],
+			Rate:         rate(first.processed[i], last.processed[i]),
+			LastProgress: m.progress[i],
+			Stalled:      m.stalled[i],
+		}
+	}
+	return h
+}
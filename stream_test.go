@@ -0,0 +1,314 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pipeline_test
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+)
+
+// tick advances the clock by d n times, waiting each time for the monitor to
+// take its sample and set its next timer
+func tick(clock *pipeline.VirtualClock, d time.Duration, n int) {
+	for i := 0; i < n; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(d)
+	}
+	clock.BlockUntil(1)
+}
+
+func TestStreamIdle(t *testing.T) {
+	idle := make(chan time.Duration, 1)
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	cfg.Stream = pipeline.StreamConfig{
+		Interval: time.Second,
+		Idle:     5 * time.Second,
+		OnIdle: func(d time.Duration) {
+			idle <- d
+		},
+	}
+
+	generator := &AbortableGenerator{QuitChan: make(chan struct{})}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(generator)
+	p.AddStage(&CountingStage{})
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+	for p.Snapshot().Stages[0].Processed != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	tick(clock, time.Second, 4)
+	if p.Health().Idle {
+		t.Errorf(`the pipeline should not be idle yet`)
+	}
+
+	tick(clock, time.Second, 1)
+	select {
+	case d := <-idle:
+		if d != 5*time.Second {
+			t.Errorf("expected 5s idle, got %v", d)
+		}
+	default:
+		t.Errorf(`OnIdle should have been called`)
+	}
+
+	health := p.Health()
+	if !health.Idle || health.Generated != 1 || !health.LastJob.Equal(time.Unix(0, 0)) {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	h.Abort()
+	h.Wait()
+}
+
+func TestStreamStall(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	cfg.Stream = pipeline.StreamConfig{
+		Interval: time.Second,
+		Stall:    3 * time.Second,
+	}
+
+	stage := &BlockingStage{Started: make(chan struct{}), Release: make(chan struct{})}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(stage)
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+	<-stage.Started
+
+	tick(clock, time.Second, 3)
+	if err := p.Live(); err != nil {
+		t.Errorf("error should be nil, got %v", err)
+	}
+
+	tick(clock, time.Second, 1)
+	if err := p.Live(); !errors.Is(err, pipeline.ErrStalled) || !strings.Contains(err.Error(), "BlockingStage") {
+		t.Errorf("expected the stage to be stalled, got %v", err)
+	}
+
+	close(stage.Release)
+	h.Wait()
+
+	if err := p.Live(); err != nil {
+		t.Errorf("error should be nil once drained, got %v", err)
+	}
+}
+
+func TestStreamStallPaused(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	cfg.Stream = pipeline.StreamConfig{
+		Interval: time.Second,
+		Stall:    3 * time.Second,
+	}
+
+	stage := &BlockingStage{Started: make(chan struct{}), Release: make(chan struct{})}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(stage)
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+	<-stage.Started
+
+	// the blocked stage is not stalled while the pipeline is paused
+	p.Pause()
+	tick(clock, time.Second, 5)
+	if err := p.Live(); err != nil {
+		t.Errorf("error should be nil while paused, got %v", err)
+	}
+
+	p.Resume()
+	tick(clock, time.Second, 4)
+	if err := p.Live(); !errors.Is(err, pipeline.ErrStalled) {
+		t.Errorf("expected the stage to be stalled once resumed, got %v", err)
+	}
+
+	close(stage.Release)
+	h.Wait()
+}
+
+func TestStreamStallPausedStage(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	cfg.Stream = pipeline.StreamConfig{
+		Interval: time.Second,
+		Stall:    3 * time.Second,
+	}
+
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{})
+	p.PauseStage("CountingStage")
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+	for p.Snapshot().Stages[0].Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the paused stage has queued jobs but is not stalled
+	tick(clock, time.Second, 5)
+	if err := p.Live(); err != nil {
+		t.Errorf("error should be nil while the stage is paused, got %v", err)
+	}
+
+	p.ResumeStage("CountingStage")
+	h.Wait()
+}
+
+func TestStreamStallDownstream(t *testing.T) {
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	cfg.Depth = 1
+	cfg.Stream = pipeline.StreamConfig{
+		Interval: time.Second,
+		Stall:    3 * time.Second,
+	}
+
+	stage := &BlockingStage{Started: make(chan struct{}), Release: make(chan struct{})}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{})
+	p.AddStage(stage)
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+	for p.Snapshot().Stages[0].Blocked == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the fast stage is held up by the full queue of the hung stage
+	tick(clock, time.Second, 4)
+	if err := p.Live(); !errors.Is(err, pipeline.ErrStalled) || !strings.Contains(err.Error(), "BlockingStage") {
+		t.Errorf("expected the hung stage to be stalled, got %v", err)
+	}
+	if p.Health().Stages[0].Stalled {
+		t.Errorf("expected the blocked stage not to be stalled")
+	}
+
+	close(stage.Release)
+	h.Wait()
+}
+
+func TestStreamThroughput(t *testing.T) {
+	var buf bytes.Buffer
+	clock := pipeline.NewVirtualClock(time.Unix(0, 0))
+	cfg := pipeline.DefaultConfig()
+	cfg.Clock = clock
+	cfg.Logger = log.New(&buf, "", 0)
+	cfg.Stream = pipeline.StreamConfig{
+		Interval:  time.Second,
+		Window:    10 * time.Second,
+		Heartbeat: time.Second,
+	}
+
+	generator := &ChanGenerator{Jobs: make(chan interface{})}
+	counting := &CountingStage{}
+	p := pipeline.NewWithConfig(cfg)
+	p.SetGenerator(generator)
+	p.AddStage(counting)
+
+	h, err := p.Start()
+	if err != nil {
+		t.Fatalf(`error should be nil`)
+	}
+	clock.BlockUntil(1)
+
+	for i := 1; i <= 10; i++ {
+		generator.Jobs <- i
+	}
+	for p.Snapshot().Stages[0].Processed != 10 {
+		time.Sleep(time.Millisecond)
+	}
+	tick(clock, time.Second, 1)
+
+	health := p.Health()
+	if health.Rate != 10 || health.Stages[0].Rate != 10 || health.Stages[0].Processed != 10 {
+		t.Errorf("expected 10 jobs/s, got %+v", health)
+	}
+
+	close(generator.Jobs)
+	h.Wait()
+
+	if !strings.Contains(buf.String(), "stage='CountingStage', action=heartbeat, processed=10, rate=10.00") {
+		t.Errorf("expected a heartbeat in the log\n%v", buf.String())
+	}
+}
+
+func TestHealthWithoutStream(t *testing.T) {
+	p := pipeline.New()
+	p.SetGenerator(&CountsToTenGenerator{})
+	p.AddStage(&CountingStage{})
+	p.Run()
+
+	health := p.Health()
+	if len(health.Stages) != 1 || health.Stages[0].Processed != 10 || health.Stages[0].Rate != 0 {
+		t.Errorf("unexpected health %+v", health)
+	}
+	if err := p.Live(); err != nil {
+		t.Errorf(`error should be nil`)
+	}
+}
+
+/* test generator */
+type ChanGenerator struct {
+	Jobs chan interface{}
+}
+
+func (g *ChanGenerator) Name() string {
+	return "ChanGenerator"
+}
+
+func (g *ChanGenerator) Next() interface{} {
+	return <-g.Jobs
+}
+
+func (g *ChanGenerator) Abort() {
+}
@@ -0,0 +1,235 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package window provides stages that group jobs into tumbling or sliding
+// windows of event time and emit one aggregate job per window.
+//
+// The event time of each job is extracted by a user function. The stage keeps
+// a watermark, the latest event time it has seen, and closes a window once the
+// watermark passes the end of the window plus the allowed lateness. A job that
+// arrives after every window it belongs to has closed is late; it is dropped,
+// counted and passed to OnLate. Windows still open when the input ends are
+// emitted then.
+//
+//	p.AddStage(window.Tumbling(time.Minute, window.Options{
+//		Time:     func(job interface{}) time.Time { return job.(*Event).At },
+//		Lateness: 10 * time.Second,
+//	}))
+//
+// A window stage implements pipeline.Emitter and pipeline.Flusher; it runs a
+// single worker and only its aggregates reach the next stage.
+package window
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Window is a group of jobs whose event times fall in [Start, End). Key is
+// set when the stage groups by key.
+type Window struct {
+	_     struct{}
+	Key   interface{}
+	Start time.Time
+	End   time.Time
+	Jobs  []interface{}
+	seq   uint64
+}
+
+// Options configures a window stage. Time is required. Key, when set, keeps a
+// separate set of windows for each key. Aggregate turns a closed window into
+// the job emitted downstream and defaults to emitting the *Window itself.
+type Options struct {
+	_         struct{}
+	Name      string
+	Time      func(job interface{}) time.Time
+	Key       func(job interface{}) interface{}
+	Lateness  time.Duration
+	Aggregate func(w *Window) interface{}
+	OnLate    func(job interface{})
+}
+
+// Stage groups jobs into windows of event time
+type Stage struct {
+	_         struct{}
+	size      time.Duration
+	slide     time.Duration
+	opts      Options
+	mu        sync.Mutex
+	watermark time.Time
+	open      map[windowKey]*Window
+	seq       uint64
+	late      uint64
+}
+
+type windowKey struct {
+	key   interface{}
+	start int64
+}
+
+// Tumbling returns a stage that groups jobs into consecutive windows of size
+func Tumbling(size time.Duration, opts Options) *Stage {
+	return Sliding(size, size, opts)
+}
+
+// Sliding returns a stage that groups jobs into windows of size starting
+// every slide; a job belongs to every window that covers its event time.
+// Windows are aligned to the zero time.
+func Sliding(size, slide time.Duration, opts Options) *Stage {
+	if size <= 0 || slide <= 0 {
+		panic("window: size and slide must be greater than 0")
+	}
+	if opts.Time == nil {
+		panic("window: Options.Time is required")
+	}
+	if opts.Name == "" {
+		opts.Name = "Window"
+	}
+	if opts.Aggregate == nil {
+		opts.Aggregate = func(w *Window) interface{} { return w }
+	}
+
+	return &Stage{
+		size:  size,
+		slide: slide,
+		opts:  opts,
+		open:  make(map[windowKey]*Window),
+	}
+}
+
+// Name implements pipeline.Stage
+func (s *Stage) Name() string {
+	return s.opts.Name
+}
+
+// Concurrency implements pipeline.Stage; the windows are kept by one worker
+func (s *Stage) Concurrency() int {
+	return 1
+}
+
+// Process implements pipeline.Stage. The pipeline calls Emit instead; Process
+// adds the job to its windows and discards any that close.
+func (s *Stage) Process(job interface{}) {
+	s.Emit(job, func(interface{}) {})
+}
+
+// Emit implements pipeline.Emitter
+func (s *Stage) Emit(job interface{}, emit func(interface{})) {
+	s.mu.Lock()
+	closed := s.add(job)
+	s.mu.Unlock()
+
+	for _, w := range closed {
+		emit(s.opts.Aggregate(w))
+	}
+}
+
+// Flush implements pipeline.Flusher, emitting every open window
+func (s *Stage) Flush(emit func(interface{})) {
+	s.mu.Lock()
+	closed := s.close(func(*Window) bool { return true })
+	s.mu.Unlock()
+
+	for _, w := range closed {
+		emit(s.opts.Aggregate(w))
+	}
+}
+
+// Late returns the number of late jobs dropped
+func (s *Stage) Late() uint64 {
+	return atomic.LoadUint64(&s.late)
+}
+
+// Open returns the number of windows not yet emitted
+func (s *Stage) Open() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.open)
+}
+
+// add places the job in its windows and returns the windows closed by the
+// new watermark; s.mu must be held
+func (s *Stage) add(job interface{}) []*Window {
+	t := s.opts.Time(job)
+	var key interface{}
+	if s.opts.Key != nil {
+		key = s.opts.Key(job)
+	}
+
+	added := false
+	for start := t.Truncate(s.slide); start.Add(s.size).After(t); start = start.Add(-s.slide) {
+		end := start.Add(s.size)
+		if s.expired(end) {
+			break // the earlier windows have closed as well
+		}
+
+		k := windowKey{key: key, start: start.UnixNano()}
+		w, ok := s.open[k]
+		if !ok {
+			s.seq++
+			w = &Window{Key: key, Start: start, End: end, seq: s.seq}
+			s.open[k] = w
+		}
+		w.Jobs = append(w.Jobs, job)
+		added = true
+	}
+
+	if !added {
+		atomic.AddUint64(&s.late, 1)
+		if s.opts.OnLate != nil {
+			s.opts.OnLate(job)
+		}
+		return nil
+	}
+
+	if t.After(s.watermark) {
+		s.watermark = t
+	}
+	return s.close(func(w *Window) bool { return s.expired(w.End) })
+}
+
+// expired reports whether a window ending at end has closed
+func (s *Stage) expired(end time.Time) bool {
+	return !s.watermark.IsZero() && !end.Add(s.opts.Lateness).After(s.watermark)
+}
+
+// close removes the windows matching done, in order of their end time and
+// then their creation; s.mu must be held
+func (s *Stage) close(done func(*Window) bool) []*Window {
+	var closed []*Window
+	for k, w := range s.open {
+		if done(w) {
+			closed = append(closed, w)
+			delete(s.open, k)
+		}
+	}
+
+	sort.Slice(closed, func(i, j int) bool {
+		if !closed[i].End.Equal(closed[j].End) {
+			return closed[i].End.Before(closed[j].End)
+		}
+		return closed[i].seq < closed[j].seq
+	})
+	return closed
+}
@@ -0,0 +1,156 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014-2016 Joshua Boelter
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package window_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jboelter/pipeline"
+	"github.com/jboelter/pipeline/pipelinetest"
+	"github.com/jboelter/pipeline/window"
+)
+
+type event struct {
+	at  time.Duration
+	key string
+}
+
+func events(key string, at ...time.Duration) []interface{} {
+	jobs := make([]interface{}, len(at))
+	for i, d := range at {
+		jobs[i] = &event{at: d, key: key}
+	}
+	return jobs
+}
+
+func options() window.Options {
+	return window.Options{
+		Time: func(job interface{}) time.Time {
+			return time.Unix(0, 0).Add(job.(*event).at)
+		},
+		Aggregate: func(w *window.Window) interface{} {
+			start, end := w.Start.Sub(time.Unix(0, 0)), w.End.Sub(time.Unix(0, 0))
+			if w.Key != nil {
+				return fmt.Sprintf("%v:%v-%v:%v", w.Key, start, end, len(w.Jobs))
+			}
+			return fmt.Sprintf("%v-%v:%v", start, end, len(w.Jobs))
+		},
+	}
+}
+
+const s = time.Second
+
+func TestTumbling(t *testing.T) {
+	var late []interface{}
+	opts := options()
+	opts.OnLate = func(job interface{}) {
+		late = append(late, job)
+	}
+	stage := window.Tumbling(time.Minute, opts)
+
+	result, err := pipelinetest.RunStage(stage, events("", 0, 10*s, 59*s, 61*s, 30*s, 125*s), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{"0s-1m0s:3", "1m0s-2m0s:1", "2m0s-3m0s:1"})
+	if stage.Late() != 1 || len(late) != 1 || late[0].(*event).at != 30*s {
+		t.Errorf("expected the job at 30s to be late, got %v", late)
+	}
+	if stage.Open() != 0 {
+		t.Errorf("expected every window to be emitted")
+	}
+}
+
+func TestLateness(t *testing.T) {
+	opts := options()
+	opts.Lateness = 10 * s
+	stage := window.Tumbling(time.Minute, opts)
+
+	result, err := pipelinetest.RunStage(stage, events("", 0, 10*s, 59*s, 61*s, 30*s, 125*s), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{"0s-1m0s:4", "1m0s-2m0s:1", "2m0s-3m0s:1"})
+	if stage.Late() != 0 {
+		t.Errorf("expected no late jobs, got %v", stage.Late())
+	}
+}
+
+func TestSliding(t *testing.T) {
+	stage := window.Sliding(time.Minute, 30*s, options())
+
+	result, err := pipelinetest.RunStage(stage, events("", 0, 45*s, 100*s), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{
+		"-30s-30s:1", "0s-1m0s:2", "30s-1m30s:1", "1m0s-2m0s:1", "1m30s-2m30s:1",
+	})
+}
+
+func TestKeyed(t *testing.T) {
+	opts := options()
+	opts.Key = func(job interface{}) interface{} {
+		return job.(*event).key
+	}
+	stage := window.Tumbling(time.Minute, opts)
+
+	jobs := append(events("a", 0, 20*s), events("b", 30*s, 70*s)...)
+	result, err := pipelinetest.RunStage(stage, jobs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipelinetest.AssertOrder(t, result.Jobs, []interface{}{"a:0s-1m0s:2", "b:0s-1m0s:1", "b:1m0s-2m0s:1"})
+}
+
+func TestDefaultAggregate(t *testing.T) {
+	opts := options()
+	opts.Aggregate = nil
+
+	result, err := pipelinetest.RunStage(window.Tumbling(time.Minute, opts), events("", 0, 10*s), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Jobs) != 1 || len(result.Jobs[0].(*window.Window).Jobs) != 2 {
+		t.Errorf("expected a single *Window with 2 jobs, got %v", result.Jobs)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	recorder := &pipelinetest.Recorder{}
+	p := pipeline.New()
+	p.SetGenerator(pipelinetest.NewSliceGenerator(events("", 0, 61*s, 125*s)...))
+	p.AddStage(window.Tumbling(time.Minute, options()))
+	p.AddStage(recorder)
+
+	if err := p.Simulate(1); err != nil {
+		t.Fatal(err)
+	}
+	pipelinetest.AssertOrder(t, recorder.Jobs(), []interface{}{"0s-1m0s:1", "1m0s-2m0s:1", "2m0s-3m0s:1"})
+}